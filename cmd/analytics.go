@@ -2,11 +2,9 @@ package cmd
 
 import (
 	"fmt"
-	"os"
-	"text/tabwriter"
+	"sort"
 
 	"github.com/saurabh12nxf/registry-mirror/internal/analytics"
-	"github.com/saurabh12nxf/registry-mirror/internal/storage"
 	"github.com/spf13/cobra"
 )
 
@@ -22,7 +20,7 @@ func init() {
 }
 
 func runAnalytics(cmd *cobra.Command, args []string) error {
-	db, err := storage.NewDB()
+	db, err := openDB()
 	if err != nil {
 		return fmt.Errorf("failed to open database: %w", err)
 	}
@@ -33,17 +31,38 @@ func runAnalytics(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("failed to generate report: %w", err)
 	}
+	if jsonOutput() {
+		return printJSON(report)
+	}
 
 	fmt.Println("📊 Registry Mirror Analytics")
 	fmt.Println("===========================")
 
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	w := newTable()
 	fmt.Fprintf(w, "unique images cached:\t%d\n", report.TotalImages)
 	fmt.Fprintf(w, "total data served:\t%s\n", report.TotalBandwidth)
 	fmt.Fprintf(w, "estimated time saved:\t%s\n", report.TimeSaved)
+	fmt.Fprintf(w, "already present (skipped):\t%s\n", report.TotalSkipped)
 	fmt.Fprintf(w, "average throughput:\t%s\n", report.AvgSpeed)
+	fmt.Fprintf(w, "failed syncs:\t%d\n", report.FailedSyncs)
 	w.Flush()
 
+	if len(report.FailuresByCategory) > 0 {
+		fmt.Println("\n❌ Failures in the last 30 days")
+		categories := make([]string, 0, len(report.FailuresByCategory))
+		for c := range report.FailuresByCategory {
+			categories = append(categories, c)
+		}
+		sort.Strings(categories)
+		for _, c := range categories {
+			label := c
+			if label == "" {
+				label = "unclassified"
+			}
+			fmt.Printf("   %-16s %d\n", label, report.FailuresByCategory[c])
+		}
+	}
+
 	fmt.Println("\n💡 Tip: Run 'registry-mirror auto' to pre-fetch popular images.")
 	return nil
 }
@@ -1,11 +1,13 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/saurabh12nxf/registry-mirror/internal/cache"
+	"github.com/saurabh12nxf/registry-mirror/internal/config"
+	"github.com/saurabh12nxf/registry-mirror/internal/discover"
 	"github.com/saurabh12nxf/registry-mirror/internal/mirror"
-	"github.com/saurabh12nxf/registry-mirror/internal/storage"
 	"github.com/spf13/cobra"
 )
 
@@ -18,16 +20,37 @@ var autoCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(autoCmd)
-	autoCmd.Flags().IntP("top", "t", 5, "number of top images to mirror")
-	autoCmd.Flags().BoolP("dry-run", "d", false, "show what would be mirrored without acting")
+	autoCmd.Flags().IntP("top", "t", config.Default().Auto.Top, "number of top images to mirror")
+	autoCmd.Flags().BoolP("dry-run", "d", false, "show what would be mirrored from local data only, without acting")
+	autoCmd.Flags().Duration("half-life", cache.DefaultHalfLife, "time after which a pull counts half as much")
+	autoCmd.Flags().String("from-dir", "", "use images discovered in this source tree instead of the built-in popular list")
+	autoCmd.Flags().StringSlice("catalog", nil, "curated image catalogs to pick from, by name or catalog file (e.g. ml,web)")
+	bindFlag(autoCmd, "top", "auto.top")
+	bindFlag(autoCmd, "half-life", "auto.half_life")
+}
+
+// autoOutput is the JSON document of 'auto'; Results is left out of dry runs
+type autoOutput struct {
+	DryRun      bool             `json:"dry_run"`
+	Suggestions []autoSuggestion `json:"suggestions"`
+	Results     []syncOutcome    `json:"results,omitempty"`
+}
+
+type autoSuggestion struct {
+	Image string  `json:"image"`
+	Score float64 `json:"score"`
+	Why   string  `json:"why"`
 }
 
 func runAuto(cmd *cobra.Command, args []string) error {
-	top, _ := cmd.Flags().GetInt("top")
+	top := cfg.Auto.Top
 	dryRun, _ := cmd.Flags().GetBool("dry-run")
-	registry, _ := cmd.Flags().GetString("registry")
+	fromDir, _ := cmd.Flags().GetString("from-dir")
+	catalogNames, _ := cmd.Flags().GetStringSlice("catalog")
+	registryAddr := cfg.Registry
+	var out autoOutput
 
-	db, err := storage.NewDB()
+	db, err := openDB()
 	if err != nil {
 		return fmt.Errorf("failed to open db: %w", err)
 	}
@@ -36,42 +59,113 @@ func runAuto(cmd *cobra.Command, args []string) error {
 	fmt.Printf("🔮 Analyzing usage patterns to predict top %d images...\n", top)
 
 	predictor := cache.NewPredictor(db)
+	predictor.SetHalfLife(cfg.Auto.HalfLife)
+	if !dryRun {
+		// Checking tags against Docker Hub costs requests, a dry run only uses what's recorded
+		predictor.UseRegistry(newRegistryClient())
+	}
+
+	var candidates []cache.Candidate
+	if len(catalogNames) > 0 || fromDir == "" {
+		if len(catalogNames) == 0 {
+			catalogNames = []string{cache.DefaultCatalog}
+		}
+		catalogs := cache.NewCatalogs(cfg.Catalogs, cache.DefaultCatalogDir())
+		fromCatalogs, err := catalogs.Resolve(catalogNames)
+		if err != nil {
+			return err
+		}
+		candidates = append(candidates, fromCatalogs...)
+	}
+
+	if fromDir != "" {
+		images, err := discover.Scan(fromDir)
+		if err != nil {
+			return fmt.Errorf("failed to scan %s: %w", fromDir, err)
+		}
+		fmt.Printf("🔎 Discovered %d images in %s\n", len(images), fromDir)
+		for _, img := range images {
+			candidates = append(candidates, cache.Candidate{Name: img.Ref, Source: "used in " + img.Sources[0]})
+		}
+	}
+	predictor.SetCandidates(candidates)
 	suggestions, err := predictor.PredictTopImages(top)
 	if err != nil {
 		return err
 	}
 
+	if jsonOutput() {
+		defer func() { printJSON(out) }()
+		out.DryRun = dryRun
+		for _, img := range suggestions {
+			out.Suggestions = append(out.Suggestions, autoSuggestion{Image: img.Name, Score: img.Score, Why: img.Explain()})
+		}
+		out.Suggestions = nonNil(out.Suggestions)
+	}
+
 	if len(suggestions) == 0 {
 		fmt.Println("✨ Your registry is up to date! No new popular images found to mirror.")
 		return nil
 	}
 
-	fmt.Println("📋 Proposed Auto-Mirror List:")
-	for _, img := range suggestions {
-		fmt.Printf("   - %s\n", img.Name)
-	}
-
 	if dryRun {
+		fmt.Println("📋 Proposed Auto-Mirror List:")
+		w := newTable()
+		fmt.Fprintln(w, "IMAGE\tSCORE\tWHY")
+		for _, img := range suggestions {
+			fmt.Fprintf(w, "%s\t%.2f\t%s\n", img.Name, img.Score, img.Explain())
+		}
+		w.Flush()
+
 		fmt.Println("\nDry run completed. No actions taken.")
 		return nil
 	}
 
+	fmt.Println("📋 Proposed Auto-Mirror List:")
+	for _, img := range suggestions {
+		fmt.Printf("   - %s\n", img.Name)
+	}
+
 	fmt.Println("\n🚀 Starting auto-mirror process...")
-	syncer := mirror.NewSyncer(registry, 3)
-	tracker := mirror.NewTracker(db)
+	session, err := newSyncSession(db, registryAddr, cfg.Parallel, false)
+	if err != nil {
+		return err
+	}
+	stop := session.interruptOnSignal()
+	defer stop()
+	defer applyRetention(db)
 
+	var synced, deferred, skipped, failed int
 	for i, img := range suggestions {
 		fmt.Printf("[%d/%d] Mirroring %s...\n", i+1, len(suggestions), img.Name)
 
-		err := syncer.Sync(img.Name, false)
-		if err != nil {
-			fmt.Printf("❌ Failed to sync %s: %v\n", img.Name, err)
-			tracker.TrackSyncError(img.Name, err)
-			continue
+		_, err := session.sync(img.Name, false, false)
+		if jsonOutput() {
+			out.Results = append(out.Results, session.outcome(img.Name, err))
+		}
+		if errors.Is(err, errInterrupted) {
+			return err
+		}
+		var spaceErr *cache.SpaceError
+		switch {
+		case errors.As(err, &spaceErr):
+			fmt.Printf("⏸️  Deferred %s: %v\n", img.Name, err)
+			deferred++
+		case errors.Is(err, mirror.ErrAlreadyRunning):
+			fmt.Printf("⏭️  Skipped %s: %v\n", img.Name, err)
+			skipped++
+		case err != nil:
+			fmt.Printf("❌ Failed to sync %s (%s): %v\n", img.Name, mirror.Classify(err), err)
+			failed++
+		default:
+			synced++
 		}
-		tracker.TrackSyncComplete(img.Name, 0, 0) // simplified stats
 	}
 
-	fmt.Println("\n✅ Auto-mirror completed successfully!")
+	fmt.Printf("\n📊 %d synced, %d deferred, %d skipped, %d failed\n", synced, deferred, skipped, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d syncs failed", failed, len(suggestions))
+	}
+	fmt.Println("✅ Auto-mirror completed successfully!")
 	return nil
 }
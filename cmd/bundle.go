@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/bundle"
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export [image...]",
+	Short: "Export images to a bundle for air-gapped transfer",
+	Long: `Export writes the given images (or every image in the local registry) to a
+tar bundle. With --have, blobs and manifests the target already holds are left
+out, so weekly transfers only carry what changed.
+
+Examples:
+  registry-mirror export -o full.tar
+  registry-mirror export --have have.json -o delta.tar
+  registry-mirror export nginx:latest postgres:15 --have have.json -o delta.tar`,
+	RunE: runExport,
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import <bundle>",
+	Short: "Import a bundle into the local registry",
+	Long: `Import pushes the contents of a bundle created by 'export' into the local
+registry, then checks that every blob referenced by the bundled images exists.
+
+Examples:
+  registry-mirror import delta.tar`,
+	Args: cobra.ExactArgs(1),
+	RunE: runImport,
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(importCmd)
+
+	exportCmd.Flags().StringP("output", "o", "", "bundle file to write (required)")
+	exportCmd.Flags().String("have", "", "inventory of the target registry; only the delta is exported")
+	exportCmd.MarkFlagRequired("output")
+}
+
+// exportOutput is the JSON document of 'export'
+type exportOutput struct {
+	Bundle    string   `json:"bundle"`
+	Images    []string `json:"images"`
+	Skipped   []string `json:"skipped"`
+	Manifests int      `json:"manifests"`
+	Blobs     int      `json:"blobs"`
+	Mounts    int      `json:"mounts"`
+	Bytes     int64    `json:"bytes"`
+}
+
+// importOutput is the JSON document of 'import'. Missing lists the blobs
+// that failed validation, along with the error.
+type importOutput struct {
+	Bundle       string   `json:"bundle"`
+	Images       []string `json:"images"`
+	BlobsPushed  int      `json:"blobs_pushed"`
+	BlobsMounted int      `json:"blobs_mounted"`
+	Manifests    int      `json:"manifests"`
+	Missing      []string `json:"missing"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// imageRefs lists the images of a bundle as repository:tag
+func imageRefs(idx *bundle.Index) []string {
+	refs := []string{}
+	for _, img := range idx.Images {
+		refs = append(refs, img.Repository+":"+img.Tag)
+	}
+	return refs
+}
+
+func runExport(cmd *cobra.Command, args []string) error {
+	output, _ := cmd.Flags().GetString("output")
+	havePath, _ := cmd.Flags().GetString("have")
+	registryAddr := cfg.Registry
+
+	var have *bundle.Inventory
+	if havePath != "" {
+		inv, err := bundle.LoadInventory(havePath)
+		if err != nil {
+			return fmt.Errorf("failed to load inventory: %w", err)
+		}
+		have = inv
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("failed to create bundle: %w", err)
+	}
+	defer f.Close()
+
+	fmt.Fprintf(os.Stderr, "📦 Exporting from %s to %s...\n", registryAddr, output)
+
+	exporter := bundle.NewExporter(newRegistryClient(), have)
+	idx, err := exporter.Export(context.Background(), args, f)
+	if err != nil {
+		os.Remove(output)
+		return fmt.Errorf("export failed: %w", err)
+	}
+
+	for _, img := range idx.Skipped {
+		fmt.Fprintf(os.Stderr, "   = %s (already on target)\n", img)
+	}
+	for _, img := range idx.Images {
+		fmt.Fprintf(os.Stderr, "   + %s:%s\n", img.Repository, img.Tag)
+	}
+	fmt.Fprintf(os.Stderr, "✅ Wrote %d images, %d manifests, %d blobs (%.2f MB), %d mounts\n",
+		len(idx.Images), len(idx.Manifests), len(idx.Blobs),
+		float64(idx.TotalBytes())/(1024*1024), len(idx.Mounts))
+
+	if jsonOutput() {
+		return printJSON(exportOutput{
+			Bundle: output, Images: imageRefs(idx), Skipped: nonNil(idx.Skipped),
+			Manifests: len(idx.Manifests), Blobs: len(idx.Blobs), Mounts: len(idx.Mounts),
+			Bytes: idx.TotalBytes(),
+		})
+	}
+	return nil
+}
+
+func runImport(cmd *cobra.Command, args []string) error {
+	registryAddr := cfg.Registry
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to open bundle: %w", err)
+	}
+	defer f.Close()
+
+	fmt.Printf("📥 Importing %s into %s...\n", args[0], registryAddr)
+
+	importer := bundle.NewImporter(newRegistryClient())
+	result, err := importer.Import(context.Background(), f)
+
+	var missing *bundle.MissingBlobsError
+	if errors.As(err, &missing) {
+		if jsonOutput() {
+			printJSON(importOutput{Bundle: args[0], Images: []string{}, Missing: missing.Missing, Error: err.Error()})
+			return err
+		}
+		fmt.Println("❌ Validation failed, the following blobs are missing:")
+		for _, m := range missing.Missing {
+			fmt.Printf("   - %s\n", m)
+		}
+		fmt.Println("   Re-run 'inventory' on this side and export a fresh delta.")
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("import failed: %w", err)
+	}
+
+	fmt.Printf("✅ Imported %d images (%d blobs pushed, %d mounted, %d manifests), all blobs verified\n",
+		len(result.Index.Images), result.Blobs, result.Mounts, result.Manifests)
+
+	if jsonOutput() {
+		return printJSON(importOutput{
+			Bundle: args[0], Images: imageRefs(result.Index),
+			BlobsPushed: result.Blobs, BlobsMounted: result.Mounts, Manifests: result.Manifests,
+			Missing: []string{},
+		})
+	}
+	return nil
+}
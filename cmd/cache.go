@@ -0,0 +1,529 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/cache"
+	"github.com/saurabh12nxf/registry-mirror/internal/registry"
+	"github.com/saurabh12nxf/registry-mirror/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Inspect and manage the local image cache",
+	Long: `Inspect and manage the images held by the local registry. The size limit and
+eviction policy come from the config file:
+
+  cache:
+    max_size_mb: 10000
+    policy: LRU`,
+}
+
+var cacheLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List cached images with their size and usage",
+	RunE:  runCacheLs,
+}
+
+var cacheUsageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "Show how much of the cache limit is in use",
+	RunE:  runCacheUsage,
+}
+
+var cachePlanCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Show what eviction would delete, without deleting anything",
+	RunE:  runCachePlan,
+}
+
+var cacheEvictCmd = &cobra.Command{
+	Use:   "evict [image...]",
+	Short: "Delete images from the local registry",
+	Long: `Evict deletes image manifests from the local registry and marks them evicted.
+Without arguments it evicts what the cache policy selects to get back under the
+size limit.
+
+Deleting a manifest only unlinks it: the registry reclaims layer storage when its
+garbage collector runs. Pass --gc-container (or set cache.gc_container) to run it
+in the registry container right away. The registry must be started with
+REGISTRY_STORAGE_DELETE_ENABLED=true.
+
+Examples:
+  registry-mirror cache evict --dry-run
+  registry-mirror cache evict nginx:1.25 --yes --gc-container registry`,
+	RunE: runCacheEvict,
+}
+
+var cachePinCmd = &cobra.Command{
+	Use:   "pin <image...>",
+	Short: "Protect images from eviction",
+	Long: `Pin marks images that must always stay in the local registry, whatever the
+cache policy. Images can also be pinned in the config file:
+
+  pinned:
+    - postgres:15`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runCachePin,
+}
+
+var cacheUnpinCmd = &cobra.Command{
+	Use:   "unpin <image...>",
+	Short: "Allow pinned images to be evicted again",
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  runCacheUnpin,
+}
+
+func init() {
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheLsCmd)
+	cacheCmd.AddCommand(cacheUsageCmd)
+	cacheCmd.AddCommand(cachePlanCmd)
+	cacheCmd.AddCommand(cacheEvictCmd)
+	cacheCmd.AddCommand(cachePinCmd)
+	cacheCmd.AddCommand(cacheUnpinCmd)
+
+	cacheEvictCmd.Flags().Bool("dry-run", false, "show what would be evicted without deleting anything")
+	cacheEvictCmd.Flags().BoolP("yes", "y", false, "don't ask for confirmation")
+	cacheEvictCmd.Flags().String("gc-container", "", "run the registry garbage collector in this docker container afterwards")
+	bindFlag(cacheEvictCmd, "gc-container", "cache.gc_container")
+}
+
+// evictOutput is the JSON document of 'cache evict'. On a dry run Evicted
+// lists what would be evicted; FreedBytes is left at 0 when some evictions failed.
+type evictOutput struct {
+	DryRun     bool           `json:"dry_run"`
+	Evicted    []string       `json:"evicted"`
+	Failed     []evictFailure `json:"failed"`
+	FreedBytes int64          `json:"freed_bytes"`
+}
+
+type evictFailure struct {
+	Image string `json:"image"`
+	Error string `json:"error"`
+}
+
+func runCacheEvict(cmd *cobra.Command, args []string) error {
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	yes, _ := cmd.Flags().GetBool("yes")
+	gcContainer := cfg.Cache.GCContainer
+
+	db, err := openDB()
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	store, err := openBlobStore()
+	if err != nil {
+		return fmt.Errorf("failed to open blob store: %w", err)
+	}
+
+	mgr, err := newCacheManager(db)
+	if err != nil {
+		return err
+	}
+	mgr.UseBlobStore(store)
+	mgr.UseRegistry(newRegistryClient())
+
+	images := args
+	var freed int64
+	if len(images) == 0 {
+		if images, freed, err = mgr.Clean(); err != nil {
+			return fmt.Errorf("failed to plan eviction: %w", err)
+		}
+	} else if freed, err = mgr.Freed(images); err != nil {
+		return fmt.Errorf("failed to compute freed space: %w", err)
+	}
+
+	out := evictOutput{DryRun: dryRun, Evicted: []string{}, Failed: []evictFailure{}}
+	if jsonOutput() {
+		defer func() { printJSON(out) }()
+	}
+
+	if len(images) == 0 {
+		fmt.Println("✅ Cache is within its size limit, nothing to evict.")
+		return nil
+	}
+	printPlan(mgr, images, freed)
+
+	if dryRun {
+		out.Evicted, out.FreedBytes = images, freed
+		return nil
+	}
+	if !yes && isInteractive() && !confirm(fmt.Sprintf("Evict %d image(s)?", len(images))) {
+		fmt.Println("Aborted.")
+		return nil
+	}
+
+	ctx := context.Background()
+	evicted := 0
+	done := make(map[string]bool) // name:tag in the local registry
+	for _, img := range images {
+		name, tag := registry.ParseReference(img)
+		if done[name+":"+tag] {
+			continue
+		}
+		refs, err := mgr.Evict(ctx, img)
+		if err != nil {
+			if errors.Is(err, registry.ErrDeleteDisabled) {
+				return err
+			}
+			fmt.Printf("❌ %s: %v\n", img, err)
+			out.Failed = append(out.Failed, evictFailure{Image: img, Error: err.Error()})
+			continue
+		}
+		fmt.Printf("🗑️  Evicted %s\n", img)
+		for _, ref := range refs[1:] {
+			fmt.Printf("🗑️  Evicted %s (same manifest as %s)\n", ref, img)
+		}
+		for _, ref := range refs {
+			name, tag := registry.ParseReference(ref)
+			done[name+":"+tag] = true
+		}
+		out.Evicted = append(out.Evicted, refs...)
+		evicted++
+	}
+
+	if removed, bytes, err := store.GC(); err != nil {
+		fmt.Printf("⚠️  Blob store cleanup failed: %v\n", err)
+	} else if removed > 0 {
+		fmt.Printf("🧹 Removed %d unreferenced blobs (%.2f MB) from the blob store\n", removed, float64(bytes)/(1024*1024))
+	}
+
+	if evicted == 0 {
+		return fmt.Errorf("no images were evicted")
+	}
+	if len(out.Failed) == 0 {
+		out.FreedBytes = freed
+	}
+
+	if gcContainer == "" {
+		fmt.Println("\n💡 Run the registry's garbage collector to reclaim the layers on disk:")
+		fmt.Printf("   docker exec <registry-container> %s\n", strings.Join(registryGCArgs, " "))
+		return nil
+	}
+
+	fmt.Printf("♻️  Running garbage collection in %s...\n", gcContainer)
+	if err := registryGC(gcContainer)(ctx); err != nil {
+		return fmt.Errorf("registry garbage collection failed: %w", err)
+	}
+	fmt.Println("✅ Registry garbage collection complete")
+	return nil
+}
+
+// cacheEntry is an image in the JSON output of 'cache ls'
+type cacheEntry struct {
+	Image       string    `json:"image"`
+	SizeBytes   int64     `json:"size_bytes"`
+	UniqueBytes int64     `json:"unique_bytes"`
+	LastUsed    time.Time `json:"last_used"`
+	Pulls       int       `json:"pulls"`
+	Pinned      bool      `json:"pinned"`
+}
+
+func runCacheLs(cmd *cobra.Command, args []string) error {
+	db, err := openDB()
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	mgr, err := newCacheManager(db)
+	if err != nil {
+		return err
+	}
+
+	entries, err := mgr.Entries()
+	if err != nil {
+		return fmt.Errorf("failed to list cache: %w", err)
+	}
+
+	if jsonOutput() {
+		images := []cacheEntry{}
+		for _, e := range entries {
+			images = append(images, cacheEntry{
+				Image: e.Image, SizeBytes: e.Size, UniqueBytes: e.Bytes,
+				LastUsed: e.LastUsed(), Pulls: e.Pulls, Pinned: e.Pinned,
+			})
+		}
+		return printJSON(map[string][]cacheEntry{"images": images})
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("No images cached yet. Mirror some with 'registry-mirror sync'.")
+		return nil
+	}
+
+	w := newTable()
+	fmt.Fprintln(w, "IMAGE\tSIZE\tUNIQUE\tLAST USED\tPULLS")
+	for _, e := range entries {
+		name := e.Image
+		if e.Pinned {
+			name += " 📌"
+		}
+		fmt.Fprintf(w, "%s\t%.1f MB\t%.1f MB\t%s ago\t%d\n",
+			name,
+			float64(e.Size)/(1024*1024),
+			float64(e.Bytes)/(1024*1024),
+			time.Since(e.LastUsed()).Round(time.Second),
+			e.Pulls)
+	}
+	w.Flush()
+	return nil
+}
+
+// usageOutput is the JSON document of 'cache usage'. DiskFreeBytes is -1
+// when the free disk space isn't watched.
+type usageOutput struct {
+	UsedBytes      int64   `json:"used_bytes"`
+	LimitBytes     int64   `json:"limit_bytes"`
+	Percent        float64 `json:"percent"`
+	Images         int     `json:"images"`
+	Pinned         int     `json:"pinned"`
+	Blobs          int     `json:"blobs"`
+	Policy         string  `json:"policy"`
+	DiskFreeBytes  int64   `json:"disk_free_bytes"`
+	OverLimitBytes int64   `json:"over_limit_bytes"`
+}
+
+func runCacheUsage(cmd *cobra.Command, args []string) error {
+	db, err := openDB()
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	mgr, err := newCacheManager(db)
+	if err != nil {
+		return err
+	}
+
+	blobs, used, err := db.GetCacheUsage()
+	if err != nil {
+		return fmt.Errorf("failed to compute usage: %w", err)
+	}
+	entries, err := mgr.Entries()
+	if err != nil {
+		return fmt.Errorf("failed to list cache: %w", err)
+	}
+	pinned := 0
+	for _, e := range entries {
+		if e.Pinned {
+			pinned++
+		}
+	}
+
+	limit := mgr.Limit()
+	if jsonOutput() {
+		out := usageOutput{
+			UsedBytes: used, LimitBytes: limit, Percent: percent(used, limit),
+			Images: len(entries), Pinned: pinned, Blobs: blobs, Policy: string(mgr.PolicyType()),
+			DiskFreeBytes: -1,
+		}
+		if free, err := mgr.FreeSpace(); err == nil {
+			out.DiskFreeBytes = free
+		}
+		if used > limit {
+			out.OverLimitBytes = used - limit
+		}
+		return printJSON(out)
+	}
+
+	fmt.Println("💾 Cache Usage")
+	fmt.Println("----------------------------------------")
+	fmt.Printf("Used:    %.2f MB of %.2f MB (%.1f%%)\n", float64(used)/(1024*1024), float64(limit)/(1024*1024), percent(used, limit))
+	fmt.Printf("Images:  %d (%d pinned)\n", len(entries), pinned)
+	fmt.Printf("Blobs:   %d unique\n", blobs)
+	fmt.Printf("Policy:  %s\n", mgr.PolicyType())
+	if free, err := mgr.FreeSpace(); err != nil {
+		fmt.Printf("Disk:    ⚠️  %v\n", err)
+	} else if free >= 0 {
+		fmt.Printf("Disk:    %.2f MB free on %s\n", float64(free)/(1024*1024), cfg.Cache.StoragePath)
+	}
+	if used > limit {
+		fmt.Printf("\n⚠️  Over the limit by %.2f MB, see 'registry-mirror cache plan'\n", float64(used-limit)/(1024*1024))
+	}
+	return nil
+}
+
+// planOutput is the JSON document of 'cache plan'
+type planOutput struct {
+	Policy     string   `json:"policy"`
+	LimitBytes int64    `json:"limit_bytes"`
+	Evict      []string `json:"evict"`
+	FreedBytes int64    `json:"freed_bytes"`
+}
+
+func runCachePlan(cmd *cobra.Command, args []string) error {
+	db, err := openDB()
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	mgr, err := newCacheManager(db)
+	if err != nil {
+		return err
+	}
+
+	images, freed, err := mgr.Clean()
+	if err != nil {
+		return fmt.Errorf("failed to plan eviction: %w", err)
+	}
+	if jsonOutput() {
+		return printJSON(planOutput{
+			Policy: string(mgr.PolicyType()), LimitBytes: mgr.Limit(),
+			Evict: nonNil(images), FreedBytes: freed,
+		})
+	}
+	if len(images) == 0 {
+		fmt.Println("✅ Cache is within its size limit, nothing to evict.")
+		return nil
+	}
+	printPlan(mgr, images, freed)
+	fmt.Println("\nRun 'registry-mirror cache evict' to apply it.")
+	return nil
+}
+
+func printPlan(mgr *cache.Manager, images []string, freed int64) {
+	fmt.Printf("🧹 Eviction plan (%s policy, %.2f MB limit):\n", mgr.PolicyType(), float64(mgr.Limit())/(1024*1024))
+	for _, img := range images {
+		fmt.Printf("   - %s\n", img)
+	}
+	fmt.Printf("   (Would free approx %.2f MB)\n", float64(freed)/(1024*1024))
+}
+
+func percent(part, whole int64) float64 {
+	if whole <= 0 {
+		return 0
+	}
+	return float64(part) / float64(whole) * 100
+}
+
+// newCacheManager creates a cache manager with the size limit, eviction
+// policy (cache.ttl for the TTL policy) and pins from the config
+func newCacheManager(db storage.DB) (*cache.Manager, error) {
+	policyType := cache.PolicyType(cfg.Cache.Policy)
+	policy, err := cache.NewPolicy(policyType, cfg.Cache.TTL)
+	if err != nil {
+		return nil, err
+	}
+
+	mgr := cache.NewManager(db, cfg.Cache.MaxSizeMB, policyType)
+	mgr.SetPolicy(policy)
+	mgr.SetPinned(cfg.Pinned)
+	if path := cfg.Cache.StoragePath; path != "" {
+		mgr.WatchDisk(path, cfg.Cache.MinFreeMB)
+	}
+	if cfg.Cache.GCContainer != "" {
+		mgr.UseGarbageCollector(registryGC(cfg.Cache.GCContainer))
+	}
+	return mgr, nil
+}
+
+func runCachePin(cmd *cobra.Command, args []string) error {
+	db, err := openDB()
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	mgr := cache.NewManager(db, 0, cache.PolicyLRU)
+	for _, img := range args {
+		if err := mgr.Pin(img); err != nil {
+			return fmt.Errorf("failed to pin %s: %w", img, err)
+		}
+		fmt.Printf("📌 Pinned %s\n", img)
+	}
+	if jsonOutput() {
+		return printJSON(map[string][]string{"pinned": args})
+	}
+	return nil
+}
+
+// unpinOutput is the JSON document of 'cache unpin'
+type unpinOutput struct {
+	Unpinned     []string `json:"unpinned"`
+	ConfigPinned []string `json:"config_pinned"`
+	NotPinned    []string `json:"not_pinned"`
+}
+
+func runCacheUnpin(cmd *cobra.Command, args []string) error {
+	db, err := openDB()
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	mgr := cache.NewManager(db, 0, cache.PolicyLRU)
+	mgr.SetPinned(cfg.Pinned)
+	out := unpinOutput{Unpinned: []string{}, ConfigPinned: []string{}, NotPinned: []string{}}
+	for _, img := range args {
+		removed, err := mgr.Unpin(img)
+		if err != nil {
+			return fmt.Errorf("failed to unpin %s: %w", img, err)
+		}
+
+		if pinned, _ := mgr.IsPinned(img); pinned {
+			fmt.Printf("⚠️  %s is pinned in the config file, remove it from 'pinned' there\n", img)
+			out.ConfigPinned = append(out.ConfigPinned, img)
+		} else if removed {
+			fmt.Printf("✅ Unpinned %s\n", img)
+			out.Unpinned = append(out.Unpinned, img)
+		} else {
+			fmt.Printf("%s was not pinned\n", img)
+			out.NotPinned = append(out.NotPinned, img)
+		}
+	}
+	if jsonOutput() {
+		return printJSON(out)
+	}
+	return nil
+}
+
+// pinnedImages returns the images pinned in the config file or with 'cache pin'
+func pinnedImages(db storage.DB) ([]string, error) {
+	mgr := cache.NewManager(db, 0, cache.PolicyLRU)
+	mgr.SetPinned(cfg.Pinned)
+	return mgr.Pinned()
+}
+
+// registryGCArgs runs registry:2's garbage collector with its default config.
+// Evicted manifests are deleted explicitly, so it isn't run with
+// --delete-untagged, which would also remove the platform manifests of kept
+// multi-platform images as they're only referenced by digest.
+var registryGCArgs = []string{"registry", "garbage-collect", "/etc/docker/registry/config.yml"}
+
+// registryGC runs the registry's garbage collector in a docker container
+func registryGC(container string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		out, err := exec.CommandContext(ctx, "docker", append([]string{"exec", container}, registryGCArgs...)...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%w\n%s", err, out)
+		}
+		return nil
+	}
+}
+
+// isInteractive reports whether stdin is a terminal
+func isInteractive() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// confirm asks a yes/no question on stdin, defaulting to no
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
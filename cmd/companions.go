@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/cache"
+	"github.com/spf13/cobra"
+)
+
+var companionsCmd = &cobra.Command{
+	Use:   "companions [image]",
+	Short: "Show images that are usually pulled together",
+	Long: `Companions mines the recorded pull history for images that the same client
+pulls within one session, and shows the learned rules with their confidence.
+'serve --prefetch' and 'webhook --prefetch' use these rules to fetch companions
+as soon as one of them is pulled.
+
+Examples:
+  registry-mirror companions
+  registry-mirror companions postgres:16 --min-confidence 0.3`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runCompanions,
+}
+
+func init() {
+	rootCmd.AddCommand(companionsCmd)
+
+	companionsCmd.Flags().Duration("session-gap", cache.DefaultSessionGap, "idle time after which a client's next pull starts a new session")
+	companionsCmd.Flags().Int("min-support", 2, "minimum number of sessions a pair must appear in")
+	companionsCmd.Flags().Float64("min-confidence", 0.5, "minimum confidence (0-1) for a rule to be shown")
+}
+
+func runCompanions(cmd *cobra.Command, args []string) error {
+	gap, _ := cmd.Flags().GetDuration("session-gap")
+	minSupport, _ := cmd.Flags().GetInt("min-support")
+	minConfidence, _ := cmd.Flags().GetFloat64("min-confidence")
+
+	db, err := openDB()
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	assoc := cache.NewAssociations(db, gap, minSupport, minConfidence)
+
+	var rules []cache.Rule
+	if len(args) == 1 {
+		rules, err = assoc.Companions(args[0])
+	} else {
+		rules, err = assoc.Rules()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to mine pull history: %w", err)
+	}
+
+	if jsonOutput() {
+		return printJSON(map[string][]cache.Rule{"rules": nonNil(rules)})
+	}
+
+	if len(rules) == 0 {
+		fmt.Println("No association rules yet. Record pulls with 'serve' or 'webhook' first.")
+		return nil
+	}
+
+	fmt.Printf("🔗 Learned Association Rules (%d)\n\n", len(rules))
+
+	w := newTable()
+	fmt.Fprintln(w, "WHEN PULLED\tALSO PULLED\tCONFIDENCE\tSESSIONS")
+	for _, r := range rules {
+		fmt.Fprintf(w, "%s\t%s\t%.0f%%\t%d\n", r.From, r.To, r.Confidence*100, r.Support)
+	}
+	w.Flush()
+	return nil
+}
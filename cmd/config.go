@@ -0,0 +1,337 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"go.yaml.in/yaml/v3"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Show, check and create the configuration",
+	Long: `Every setting comes from, in order of precedence:
+
+  1. a command-line flag, if given (e.g. --registry, --parallel, --top)
+  2. an environment variable: REGISTRY_MIRROR_ plus the key in upper case with
+     dots as underscores, e.g. REGISTRY_MIRROR_CACHE_MAX_SIZE_MB
+  3. the config file, $HOME/.registry-mirror.yaml or --config
+  4. the built-in default`,
+	// Unlike other commands these run with a configuration that doesn't validate
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := bindFlags(cmd); err != nil {
+			return err
+		}
+		return setupOutput(cmd, args)
+	},
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the effective configuration",
+	Long: `Show prints the configuration commands run with, after merging flags,
+environment variables, the config file and defaults. With --sources it lists
+where each value came from.`,
+	RunE: runConfigShow,
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the configuration for mistakes",
+	RunE:  runConfigValidate,
+}
+
+var configInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Write a config file with the defaults",
+	Long: `Init writes a commented config file holding the defaults to
+$HOME/.registry-mirror.yaml, or the file given with --config.`,
+	RunE: runConfigInit,
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configInitCmd)
+
+	configShowCmd.Flags().Bool("sources", false, "show where each value comes from")
+	configInitCmd.Flags().BoolP("force", "f", false, "overwrite an existing config file")
+}
+
+// configKeyAnnotation marks a flag with the config key it overrides
+const configKeyAnnotation = "registry-mirror/config-key"
+
+// bindFlag makes a flag override a config key when it's given. Flags are
+// only bound to viper for the command that runs (see bindFlags), so several
+// commands can each have a flag for the same key.
+func bindFlag(cmd *cobra.Command, name, key string) {
+	flag := cmd.Flags().Lookup(name)
+	if flag == nil {
+		flag = cmd.PersistentFlags().Lookup(name)
+	}
+	if flag.Annotations == nil {
+		flag.Annotations = make(map[string][]string)
+	}
+	flag.Annotations[configKeyAnnotation] = []string{key}
+}
+
+// bindFlags binds the flags of the command about to run to their config keys
+func bindFlags(cmd *cobra.Command) error {
+	var err error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if keys := f.Annotations[configKeyAnnotation]; len(keys) == 1 && err == nil {
+			err = viper.BindPFlag(keys[0], f)
+		}
+	})
+	return err
+}
+
+// configFile returns the config file in use, or where 'config init' writes one
+func configFile() (string, error) {
+	if path := viper.ConfigFileUsed(); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".registry-mirror.yaml"), nil
+}
+
+// configSource says where the value of a key comes from
+func configSource(cmd *cobra.Command, key string) string {
+	var source string
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		if keys := f.Annotations[configKeyAnnotation]; len(keys) == 1 && keys[0] == key {
+			source = "flag --" + f.Name
+		}
+	})
+	if source != "" {
+		return source
+	}
+
+	env := config.EnvPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+	if _, ok := os.LookupEnv(env); ok {
+		return "env " + env
+	}
+	if profile := strings.ToLower(viper.GetString("profile")); profile != "" && key != "profile" {
+		if viper.InConfig("profiles."+profile+"."+key) || key == "db" {
+			return "profile " + profile
+		}
+	}
+	if viper.InConfig(key) {
+		return "config file"
+	}
+	return "default"
+}
+
+// redacted hides the secrets of a configuration for display
+func redacted(c config.Config) config.Config {
+	if c.Upstream.Password != "" {
+		c.Upstream.Password = "********"
+	}
+	if u, err := url.Parse(c.DB); err == nil && u.User != nil {
+		c.DB = u.Redacted()
+	}
+	return c
+}
+
+// redactedValue hides the value of a secret key for display
+func redactedValue(c config.Config, key string) interface{} {
+	switch key {
+	case "upstream.password":
+		return redacted(c).Upstream.Password
+	case "db":
+		return redacted(c).DB
+	}
+	return viper.Get(key)
+}
+
+func runConfigShow(cmd *cobra.Command, args []string) error {
+	sources, _ := cmd.Flags().GetBool("sources")
+
+	if configErr != nil {
+		return configErr
+	}
+	c, err := config.Load(viper.GetViper())
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	if sources {
+		var keys []string
+		for _, key := range viper.AllKeys() {
+			if !strings.HasPrefix(key, "profiles.") {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		if jsonOutput() {
+			out := make(map[string]string)
+			for _, key := range keys {
+				out[key] = configSource(cmd, key)
+			}
+			return printJSON(map[string]map[string]string{"sources": out})
+		}
+
+		w := newTable()
+		fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s\t%v\t%s\n", key, redactedValue(*c, key), configSource(cmd, key))
+		}
+		w.Flush()
+		return nil
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(redacted(*c)); err != nil {
+		return err
+	}
+	out := buf.Bytes()
+	if jsonOutput() {
+		// Go through YAML for the snake_case keys and readable durations
+		var settings map[string]interface{}
+		if err := yaml.Unmarshal(out, &settings); err != nil {
+			return err
+		}
+		return printJSON(settings)
+	}
+
+	if path := viper.ConfigFileUsed(); path != "" {
+		fmt.Printf("# Config file: %s\n", path)
+	} else {
+		fmt.Println("# No config file, defaults and environment only")
+	}
+	if c.Profile != "" {
+		fmt.Printf("# Profile: %s\n", c.Profile)
+	}
+	fmt.Print(string(out))
+	return nil
+}
+
+// validateOutput is the JSON document of 'config validate'
+type validateOutput struct {
+	ConfigFile string   `json:"config_file"`
+	Profiles   []string `json:"profiles"`
+	Valid      bool     `json:"valid"`
+	Problems   []string `json:"problems"`
+}
+
+// problems splits a validation error into its problems
+func problems(err error) []string {
+	var verr *config.ValidationError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &verr):
+		return verr.Problems
+	}
+	return []string{err.Error()}
+}
+
+// validateProfile checks the configuration a profile results in, reading
+// the config file afresh since the global one has the selected profile merged in
+func validateProfile(name string) []string {
+	v := viper.New()
+	config.SetDefaults(v)
+	v.SetEnvPrefix(config.EnvPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+	v.SetConfigFile(viper.ConfigFileUsed())
+	v.SetConfigType("yaml")
+	if err := v.ReadInConfig(); err != nil {
+		return []string{err.Error()}
+	}
+	if err := config.ApplyProfile(v, name); err != nil {
+		return []string{err.Error()}
+	}
+	c, err := config.Load(v)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	return problems(c.Validate())
+}
+
+func runConfigValidate(cmd *cobra.Command, args []string) error {
+	out := validateOutput{ConfigFile: viper.ConfigFileUsed(), Problems: []string{}}
+
+	if configErr != nil {
+		out.Problems = append(out.Problems, configErr.Error())
+	} else if c, err := config.Load(viper.GetViper()); err != nil {
+		out.Problems = append(out.Problems, err.Error())
+	} else {
+		out.Problems = append(out.Problems, problems(c.Validate())...)
+	}
+
+	// Every profile, not just the selected one
+	for _, name := range config.Profiles(viper.GetViper()) {
+		out.Profiles = append(out.Profiles, name)
+		for _, p := range validateProfile(name) {
+			out.Problems = append(out.Problems, "profile "+name+": "+p)
+		}
+	}
+	out.Profiles = nonNil(out.Profiles)
+	out.Valid = len(out.Problems) == 0
+
+	if jsonOutput() {
+		printJSON(out)
+	} else {
+		if out.ConfigFile == "" {
+			fmt.Println("No config file found, checking defaults and environment.")
+		} else {
+			fmt.Printf("Checking %s\n", out.ConfigFile)
+		}
+		if len(out.Profiles) > 0 {
+			fmt.Printf("Profiles: %s\n", strings.Join(out.Profiles, ", "))
+		}
+		for _, p := range out.Problems {
+			fmt.Printf("❌ %s\n", p)
+		}
+		if out.Valid {
+			fmt.Println("✅ Configuration is valid")
+		}
+	}
+
+	if !out.Valid {
+		return fmt.Errorf("configuration has %d problem(s)", len(out.Problems))
+	}
+	return nil
+}
+
+func runConfigInit(cmd *cobra.Command, args []string) error {
+	force, _ := cmd.Flags().GetBool("force")
+
+	path := cfgFile
+	if path == "" {
+		var err error
+		if path, err = configFile(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := os.Stat(path); err == nil && !force {
+		return fmt.Errorf("%s already exists (pass --force to overwrite it)", path)
+	}
+	if err := os.WriteFile(path, []byte(config.Template()), 0644); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+
+	if jsonOutput() {
+		return printJSON(map[string]string{"config_file": path})
+	}
+	fmt.Printf("✅ Wrote %s\n", path)
+	return nil
+}
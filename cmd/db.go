@@ -0,0 +1,323 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/config"
+	"github.com/saurabh12nxf/registry-mirror/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Maintain the local database",
+}
+
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Bring the database schema up to date",
+	Long: `Migrate applies pending schema migrations. Every command already does this
+when it opens the database, saving a backup next to it first; this command lets
+you do it explicitly or, with --status, see what has been applied.`,
+	RunE: runDBMigrate,
+}
+
+var dbPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Delete sync history older than the retention settings",
+	Long: `Prune deletes old sync records according to retention.keep_days and
+retention.keep_per_image in the config file, or the flags given here. Running
+syncs and the latest record of every image are always kept, as is the sync
+that put a still-cached image in the cache.
+
+Retention is also applied automatically after every sync.`,
+	RunE: runDBPrune,
+}
+
+var dbVacuumCmd = &cobra.Command{
+	Use:   "vacuum",
+	Short: "Reclaim the space of deleted records",
+	RunE:  runDBVacuum,
+}
+
+func init() {
+	rootCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(dbMigrateCmd)
+	dbCmd.AddCommand(dbPruneCmd)
+	dbCmd.AddCommand(dbVacuumCmd)
+
+	dbMigrateCmd.Flags().Bool("status", false, "show applied and pending migrations without applying them")
+
+	dbPruneCmd.Flags().Int("keep-days", 0, "keep records of the last N days (default retention.keep_days)")
+	dbPruneCmd.Flags().Int("keep-per-image", 0, "keep the newest N records of every image (default retention.keep_per_image)")
+	dbPruneCmd.Flags().Bool("dry-run", false, "count what would be deleted without deleting it")
+	dbPruneCmd.Flags().Bool("vacuum", false, "vacuum the database after pruning")
+	bindFlag(dbPruneCmd, "keep-days", "retention.keep_days")
+	bindFlag(dbPruneCmd, "keep-per-image", "retention.keep_per_image")
+}
+
+// retentionPolicy builds the retention policy from the retention settings
+func retentionPolicy(r config.Retention) storage.RetentionPolicy {
+	var policy storage.RetentionPolicy
+	if r.KeepDays > 0 {
+		policy.Before = time.Now().AddDate(0, 0, -r.KeepDays)
+	}
+	policy.KeepPerImage = r.KeepPerImage
+	return policy
+}
+
+// applyRetention prunes sync history per the config file, if it sets any limits
+func applyRetention(db storage.DB) {
+	n, err := db.PruneSyncs(retentionPolicy(cfg.Retention), false)
+	if err != nil {
+		fmt.Printf("⚠️  Failed to prune sync history: %v\n", err)
+	} else if n > 0 {
+		fmt.Printf("🧹 Pruned %d old sync records\n", n)
+	}
+}
+
+// dbPath returns the database DSN from --db, REGISTRY_MIRROR_DB or the
+// config file's db key, falling back to ~/.registry-mirror.db. Besides a SQLite
+// file it may be a postgres:// URL or memory://.
+func dbPath() (string, error) {
+	path := cfg.DB
+	if path == "" {
+		return storage.DefaultPath()
+	}
+	if strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(home, path[2:])
+	}
+	return path, nil
+}
+
+// openDB opens the configured database, migrating it if needed
+func openDB() (storage.DB, error) {
+	path, err := dbPath()
+	if err != nil {
+		return nil, err
+	}
+	return storage.Open(path)
+}
+
+// migrateOutput is the JSON document of 'db migrate'; Error is set when a
+// migration failed after the ones listed in Applied
+type migrateOutput struct {
+	SchemaVersion int              `json:"schema_version"`
+	LatestVersion int              `json:"latest_version"`
+	Applied       []migrationEntry `json:"applied"`
+	Backup        string           `json:"backup,omitempty"`
+	Error         string           `json:"error,omitempty"`
+}
+
+// migrationStatusOutput is the JSON document of 'db migrate --status'
+type migrationStatusOutput struct {
+	Database      string           `json:"database"`
+	SchemaVersion int              `json:"schema_version"`
+	LatestVersion int              `json:"latest_version"`
+	Migrations    []migrationEntry `json:"migrations"`
+}
+
+// migrationEntry is a migration; AppliedAt is null while it's pending
+type migrationEntry struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+func runDBMigrate(cmd *cobra.Command, args []string) error {
+	status, _ := cmd.Flags().GetBool("status")
+
+	path, err := dbPath()
+	if err != nil {
+		return err
+	}
+
+	db, err := storage.OpenUnmigrated(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if status {
+		return printMigrationStatus(db)
+	}
+
+	applied, backup, err := db.Migrate()
+	if jsonOutput() {
+		out := migrateOutput{LatestVersion: storage.LatestVersion(), Applied: []migrationEntry{}, Backup: backup}
+		out.SchemaVersion, _ = db.SchemaVersion()
+		for _, m := range applied {
+			out.Applied = append(out.Applied, migrationEntry{Version: m.Version, Name: m.Name})
+		}
+		if err != nil {
+			out.Error = err.Error()
+		}
+		printJSON(out)
+		return err
+	}
+	if backup != "" {
+		fmt.Printf("💾 Backed up database to %s\n", backup)
+	}
+	for _, m := range applied {
+		fmt.Printf("✅ Applied migration %d: %s\n", m.Version, m.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(applied) == 0 {
+		fmt.Printf("Database is up to date (schema version %d).\n", storage.LatestVersion())
+	}
+	return nil
+}
+
+// pruneOutput is the JSON document of 'db prune'; Deleted is what would be
+// deleted on a dry run
+type pruneOutput struct {
+	Deleted int           `json:"deleted"`
+	DryRun  bool          `json:"dry_run"`
+	Vacuum  *vacuumOutput `json:"vacuum,omitempty"`
+}
+
+// vacuumOutput is the JSON document of 'db vacuum'. Sizes are -1 for
+// databases that aren't a file.
+type vacuumOutput struct {
+	Database   string `json:"database"`
+	SizeBefore int64  `json:"size_before"`
+	SizeAfter  int64  `json:"size_after"`
+}
+
+func runDBPrune(cmd *cobra.Command, args []string) error {
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	vacuum, _ := cmd.Flags().GetBool("vacuum")
+
+	policy := retentionPolicy(cfg.Retention)
+	if policy.IsZero() {
+		return fmt.Errorf("no retention limits: set retention.keep_days or retention.keep_per_image, or pass --keep-days or --keep-per-image")
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	n, err := db.PruneSyncs(policy, dryRun)
+	if err != nil {
+		return fmt.Errorf("failed to prune sync history: %w", err)
+	}
+	if jsonOutput() {
+		out := pruneOutput{Deleted: n, DryRun: dryRun}
+		if vacuum && !dryRun {
+			if out.Vacuum, err = measureVacuum(db); err != nil {
+				return err
+			}
+		}
+		return printJSON(out)
+	}
+	if dryRun {
+		fmt.Printf("Would delete %d sync records.\n", n)
+		return nil
+	}
+	fmt.Printf("🧹 Deleted %d sync records\n", n)
+
+	if vacuum {
+		return vacuumDB(db)
+	}
+	return nil
+}
+
+func runDBVacuum(cmd *cobra.Command, args []string) error {
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if jsonOutput() {
+		out, err := measureVacuum(db)
+		if err != nil {
+			return err
+		}
+		return printJSON(out)
+	}
+	return vacuumDB(db)
+}
+
+func vacuumDB(db storage.DB) error {
+	out, err := measureVacuum(db)
+	if err != nil {
+		return err
+	}
+
+	if out.SizeBefore < 0 {
+		fmt.Println("✅ Vacuumed database")
+		return nil
+	}
+	fmt.Printf("✅ Vacuumed %s: %.1f MB -> %.1f MB\n", out.Database, float64(out.SizeBefore)/(1024*1024), float64(out.SizeAfter)/(1024*1024))
+	return nil
+}
+
+// measureVacuum vacuums the database, measuring its file before and after
+func measureVacuum(db storage.DB) (*vacuumOutput, error) {
+	out := &vacuumOutput{Database: db.Path(), SizeBefore: fileSize(db.Path())}
+	if err := db.Vacuum(); err != nil {
+		return nil, fmt.Errorf("failed to vacuum database: %w", err)
+	}
+	out.SizeAfter = fileSize(db.Path())
+	return out, nil
+}
+
+// fileSize returns the size of a file, or -1 if it isn't one (e.g. a postgres:// DSN)
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return -1
+	}
+	return info.Size()
+}
+
+func printMigrationStatus(db storage.DB) error {
+	migrations, err := db.MigrationStatus()
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	if jsonOutput() {
+		out := migrationStatusOutput{Database: db.Path(), SchemaVersion: version, LatestVersion: storage.LatestVersion(), Migrations: []migrationEntry{}}
+		for _, m := range migrations {
+			entry := migrationEntry{Version: m.Version, Name: m.Name}
+			if !m.Applied.IsZero() {
+				applied := m.Applied
+				entry.AppliedAt = &applied
+			}
+			out.Migrations = append(out.Migrations, entry)
+		}
+		return printJSON(out)
+	}
+
+	fmt.Printf("🗄️  %s (schema version %d of %d)\n\n", db.Path(), version, storage.LatestVersion())
+
+	w := newTable()
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS")
+	for _, m := range migrations {
+		state := "⏳ pending"
+		if !m.Applied.IsZero() {
+			state = "✅ applied " + m.Applied.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", m.Version, m.Name, state)
+	}
+	w.Flush()
+	return nil
+}
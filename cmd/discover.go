@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/discover"
+	"github.com/spf13/cobra"
+)
+
+var discoverCmd = &cobra.Command{
+	Use:   "discover [dir]",
+	Short: "Find the images a project uses",
+	Long: `Discover scans a source tree for Dockerfiles (including multi-stage builds and
+ARG defaults), docker-compose files, Kubernetes manifests, Helm values and GitHub
+Actions workflows, and lists every image they reference.
+
+Examples:
+  registry-mirror discover ./
+  registry-mirror discover ~/src/shop --quiet | xargs -n1 registry-mirror sync
+  registry-mirror auto --from-dir ./`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runDiscover,
+}
+
+func init() {
+	rootCmd.AddCommand(discoverCmd)
+	discoverCmd.Flags().BoolP("quiet", "q", false, "only print image references")
+}
+
+func runDiscover(cmd *cobra.Command, args []string) error {
+	dir := "."
+	if len(args) > 0 {
+		dir = args[0]
+	}
+	quiet, _ := cmd.Flags().GetBool("quiet")
+
+	images, err := discover.Scan(dir)
+	if err != nil {
+		return fmt.Errorf("failed to scan %s: %w", dir, err)
+	}
+
+	if jsonOutput() {
+		return printJSON(map[string][]discover.Image{"images": nonNil(images)})
+	}
+
+	if quiet {
+		for _, img := range images {
+			fmt.Println(img.Ref)
+		}
+		return nil
+	}
+
+	if len(images) == 0 {
+		fmt.Printf("No image references found in %s\n", dir)
+		return nil
+	}
+
+	fmt.Printf("🔎 Found %d images in %s\n\n", len(images), dir)
+
+	w := newTable()
+	fmt.Fprintln(w, "IMAGE\tFOUND IN")
+	for _, img := range images {
+		fmt.Fprintf(w, "%s\t%s\n", img.Ref, strings.Join(img.Sources, ", "))
+	}
+	w.Flush()
+	return nil
+}
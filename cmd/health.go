@@ -6,8 +6,10 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/saurabh12nxf/registry-mirror/internal/storage"
 	"github.com/spf13/cobra"
 )
 
@@ -22,48 +24,85 @@ func init() {
 	rootCmd.AddCommand(healthCmd)
 }
 
+// healthCheck is one check of 'health'; healthOutput is its JSON document
+type healthCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type healthOutput struct {
+	Healthy bool          `json:"healthy"`
+	Checks  []healthCheck `json:"checks"`
+}
+
 func runHealth(cmd *cobra.Command, args []string) {
-	registry, _ := cmd.Flags().GetString("registry")
+	registry := cfg.Registry
 
-	fmt.Println("🏥 System Health Check")
-	fmt.Println("=====================")
+	var checks []healthCheck
+
+	registryCheck := healthCheck{Name: "registry", OK: checkRegistry(registry), Detail: "http://" + registry}
+	checks = append(checks, registryCheck)
+
+	dbCheck := healthCheck{Name: "database"}
+	name, err := checkDB()
+	dbCheck.OK = err == nil
+	if err != nil {
+		dbCheck.Detail = err.Error()
+	} else {
+		dbCheck.Detail = name
+	}
+	checks = append(checks, dbCheck)
+
+	checks = append(checks, healthCheck{Name: "storage", OK: checkStorage()})
+	checks = append(checks, healthCheck{Name: "docker_hub", OK: checkInternet()})
 
 	allGood := true
+	for _, c := range checks {
+		allGood = allGood && c.OK
+	}
+
+	if jsonOutput() {
+		printJSON(healthOutput{Healthy: allGood, Checks: checks})
+		if !allGood {
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Println("🏥 System Health Check")
+	fmt.Println("=====================")
 
 	// 1. Check Registry Connectivity
 	fmt.Print("Checking Local Registry...")
-	if checkRegistry(registry) {
+	if registryCheck.OK {
 		fmt.Println(" ✅ Online")
 	} else {
-		fmt.Printf(" ❌ Unreachable (http://%s)\n", registry)
-		allGood = false
+		fmt.Printf(" ❌ Unreachable (%s)\n", registryCheck.Detail)
 	}
 
 	// 2. Check Database
 	fmt.Print("Checking Database...")
-	if checkDB() {
-		fmt.Println("       ✅ Connected")
+	if dbCheck.OK {
+		fmt.Printf("       ✅ Connected (%s)\n", dbCheck.Detail)
 	} else {
-		fmt.Println("       ❌ Error")
-		allGood = false
+		fmt.Printf("       ❌ Error (%s)\n", dbCheck.Detail)
 	}
 
 	// 3. Check Disk Space (Simple check if writable)
 	fmt.Print("Checking Storage...")
-	if checkStorage() {
+	if checks[2].OK {
 		fmt.Println("        ✅ Writable")
 	} else {
 		fmt.Println("        ❌ Error")
-		allGood = false
 	}
 
 	// 4. Check Internet
 	fmt.Print("Checking Docker Hub...")
-	if checkInternet() {
+	if checks[3].OK {
 		fmt.Println("     ✅ Reachable")
 	} else {
 		fmt.Println("     ❌ Unreachable")
-		allGood = false
 	}
 
 	fmt.Println("---------------------")
@@ -71,6 +110,7 @@ func runHealth(cmd *cobra.Command, args []string) {
 		fmt.Println("✨ System is healthy and ready to mirror!")
 	} else {
 		fmt.Println("⚠️  Issues detected. Please review above.")
+		finishOutput()
 		os.Exit(1)
 	}
 }
@@ -90,11 +130,38 @@ func checkRegistry(addr string) bool {
 	return resp.StatusCode == 200 || resp.StatusCode == 401
 }
 
-func checkDB() bool {
-	home, _ := os.UserHomeDir()
-	dbPath := filepath.Join(home, ".registry-mirror.db")
-	_, err := os.Stat(dbPath)
-	return err == nil || os.IsNotExist(err) // It's fine if it doesn't exist yet, it's writable
+// checkDB returns where the database lives if it's reachable, intact and
+// up to date. Unlike other commands it never creates or migrates it.
+func checkDB() (string, error) {
+	path, err := dbPath()
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case strings.HasPrefix(path, "postgres://"), strings.HasPrefix(path, "postgresql://"), path == "memory://", path == ":memory:":
+	default:
+		if _, err := os.Stat(strings.TrimPrefix(path, "sqlite://")); err != nil {
+			return "", fmt.Errorf("no database at %s", path)
+		}
+	}
+
+	db, err := storage.OpenUnmigrated(path)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	if err := db.Check(); err != nil {
+		return db.Path(), err
+	}
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return db.Path(), fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if version < storage.LatestVersion() {
+		return db.Path(), fmt.Errorf("migrations pending (schema version %d of %d), run 'registry-mirror db migrate'", version, storage.LatestVersion())
+	}
+	return db.Path(), nil
 }
 
 func checkStorage() bool {
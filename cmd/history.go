@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Work with the sync history",
+}
+
+var historyExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export sync records as CSV, JSON or NDJSON",
+	Long: `Export writes sync records, newest first, for spreadsheets and other tools.
+
+--since and --until take a date (2026-01-31), an RFC 3339 time, or an age such
+as 36h or 7d.
+
+Examples:
+  registry-mirror history export > syncs.csv
+  registry-mirror history export --format ndjson --status failed --since 7d
+  registry-mirror history export --format json --image nginx:latest -o nginx.json`,
+	RunE: runHistoryExport,
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.AddCommand(historyExportCmd)
+
+	historyExportCmd.Flags().String("format", "csv", "output format: csv, json or ndjson")
+	historyExportCmd.Flags().StringP("output", "o", "", "write to a file instead of stdout")
+	historyExportCmd.Flags().String("image", "", "only export syncs of this image")
+	historyExportCmd.Flags().String("status", "", "only export syncs with this status (completed, failed, evicted, running, interrupted)")
+	historyExportCmd.Flags().String("category", "", "only export failures of this error category")
+	historyExportCmd.Flags().String("since", "", "only export syncs at or after this time")
+	historyExportCmd.Flags().String("until", "", "only export syncs before this time")
+	historyExportCmd.Flags().Int("limit", 0, "export at most N records (0 for all)")
+}
+
+func runHistoryExport(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+	if jsonOutput() && !cmd.Flags().Changed("format") {
+		format = "json"
+	}
+	output, _ := cmd.Flags().GetString("output")
+	since, _ := cmd.Flags().GetString("since")
+	until, _ := cmd.Flags().GetString("until")
+
+	filter := storage.SyncFilter{}
+	filter.Image, _ = cmd.Flags().GetString("image")
+	filter.Status, _ = cmd.Flags().GetString("status")
+	filter.ErrorCategory, _ = cmd.Flags().GetString("category")
+	filter.Limit, _ = cmd.Flags().GetInt("limit")
+
+	var err error
+	if filter.Since, err = parseTime(since); err != nil {
+		return fmt.Errorf("invalid --since: %w", err)
+	}
+	if filter.Until, err = parseTime(until); err != nil {
+		return fmt.Errorf("invalid --until: %w", err)
+	}
+
+	var write func(io.Writer, []storage.SyncRecord) error
+	switch format {
+	case "csv":
+		write = writeSyncsCSV
+	case "json":
+		write = writeSyncsJSON
+	case "ndjson":
+		write = writeSyncsNDJSON
+	default:
+		return fmt.Errorf("unknown format %q (available: csv, json, ndjson)", format)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	records, err := db.GetSyncs(filter)
+	if err != nil {
+		return fmt.Errorf("failed to fetch sync history: %w", err)
+	}
+
+	// The export is the document, so it goes to the real stdout in JSON mode too
+	out := io.Writer(stdout)
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if err := write(out, records); err != nil {
+		return err
+	}
+	if output != "" {
+		fmt.Fprintf(os.Stderr, "📤 Exported %d sync records to %s\n", len(records), output)
+	}
+	return nil
+}
+
+// parseTime parses a date, an RFC 3339 time or an age (36h, 7d) into a point
+// in time. An empty string is the zero time.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil {
+			return time.Now().AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date, time or age", s)
+}
+
+var syncCSVHeader = []string{
+	"id", "image", "status", "timestamp", "duration_seconds", "bytes", "bytes_skipped",
+	"layers", "layers_skipped", "upstream_digest", "local_digest", "error_category", "error",
+}
+
+func writeSyncsCSV(w io.Writer, records []storage.SyncRecord) error {
+	cw := csv.NewWriter(w)
+	cw.Write(syncCSVHeader)
+	for _, r := range records {
+		cw.Write([]string{
+			strconv.Itoa(r.ID), r.Image, r.Status, r.Timestamp.Format(time.RFC3339),
+			strconv.FormatFloat(r.Duration, 'f', 3, 64),
+			strconv.FormatInt(r.Bytes, 10), strconv.FormatInt(r.BytesSkipped, 10),
+			strconv.Itoa(r.Layers), strconv.Itoa(r.LayersSkipped),
+			r.UpstreamDigest, r.LocalDigest, r.ErrorCategory, r.Error,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeSyncsJSON(w io.Writer, records []storage.SyncRecord) error {
+	if records == nil {
+		records = []storage.SyncRecord{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+func writeSyncsNDJSON(w io.Writer, records []storage.SyncRecord) error {
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/registry"
+	"github.com/spf13/cobra"
+)
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect <image>",
+	Short: "Show an image's manifest, layers and config",
+	Long: `Inspect shows what an image is made of: its manifest (or, for multi-platform
+images, the index and the manifest of one platform), the layers with their sizes
+and media types, and the decoded image config with its environment, entrypoint,
+labels, exposed ports and build history.
+
+The image is read from Docker Hub, or from the local registry with --local.
+
+Examples:
+  registry-mirror inspect nginx:latest
+  registry-mirror inspect postgres:16 --platform linux/arm64
+  registry-mirror inspect redis:7 --local --json | jq .config.config.Env`,
+	Args: cobra.ExactArgs(1),
+	RunE: runInspect,
+}
+
+func init() {
+	rootCmd.AddCommand(inspectCmd)
+
+	inspectCmd.Flags().Bool("local", false, "read the image from the local registry instead of Docker Hub")
+	inspectCmd.Flags().String("platform", "linux/"+runtime.GOARCH, "platform to inspect in a multi-platform image, os/arch[/variant]")
+	inspectCmd.Flags().Bool("raw", false, "print the manifest as the registry serves it")
+}
+
+// inspectOutput is the JSON document of 'inspect'. For a multi-platform image
+// Digest and MediaType describe the index, and Platforms lists its manifests.
+type inspectOutput struct {
+	Image          string                `json:"image"`
+	Source         string                `json:"source"`
+	Digest         string                `json:"digest"`
+	MediaType      string                `json:"media_type"`
+	Platforms      []inspectPlatform     `json:"platforms,omitempty"`
+	Platform       string                `json:"platform,omitempty"`
+	ManifestDigest string                `json:"manifest_digest"`
+	Layers         []inspectLayer        `json:"layers"`
+	TotalSize      int64                 `json:"total_size"`
+	Config         *registry.ImageConfig `json:"config"`
+}
+
+type inspectPlatform struct {
+	Platform  string `json:"platform"`
+	Digest    string `json:"digest"`
+	MediaType string `json:"media_type"`
+	Size      int64  `json:"size"`
+}
+
+type inspectLayer struct {
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+	MediaType string `json:"media_type"`
+}
+
+func runInspect(cmd *cobra.Command, args []string) error {
+	image := args[0]
+	local, _ := cmd.Flags().GetBool("local")
+	platform, _ := cmd.Flags().GetString("platform")
+	raw, _ := cmd.Flags().GetBool("raw")
+
+	ctx := context.Background()
+	client := newRegistryClient()
+	name, ref := registry.ParseReference(image)
+
+	source := "Docker Hub"
+	fetch := func(reference string) (*registry.RawManifest, error) {
+		return client.GetUpstreamManifest(ctx, name, reference, registry.ManifestAccept)
+	}
+	if local {
+		source = "local registry " + cfg.Registry
+		fetch = func(reference string) (*registry.RawManifest, error) {
+			return client.GetLocalManifest(ctx, name, reference)
+		}
+	}
+
+	top, err := fetch(ref)
+	if err != nil {
+		return fmt.Errorf("failed to fetch manifest of %s: %w", image, err)
+	}
+	if raw {
+		// The manifest is the document, with or without --json
+		_, err := stdout.Write(append(top.Data, '\n'))
+		return err
+	}
+
+	manifest, err := top.Parse()
+	if err != nil {
+		return err
+	}
+	out := inspectOutput{Image: image, Source: source, Digest: top.Digest, MediaType: mediaType(top, manifest), ManifestDigest: top.Digest}
+
+	if manifest.IsIndex() {
+		for _, d := range manifest.Manifests {
+			out.Platforms = append(out.Platforms, inspectPlatform{Platform: d.Platform.String(), Digest: d.Digest, MediaType: d.MediaType, Size: d.Size})
+		}
+
+		desc, ok := manifest.FindPlatform(platform)
+		if !ok {
+			printInspectHeader(out)
+			return fmt.Errorf("%s has no %s image, choose one of its platforms with --platform", image, platform)
+		}
+		child, err := fetch(desc.Digest)
+		if err != nil {
+			return fmt.Errorf("failed to fetch %s manifest: %w", platform, err)
+		}
+		if manifest, err = child.Parse(); err != nil {
+			return err
+		}
+		out.Platform = desc.Platform.String()
+		out.ManifestDigest = child.Digest
+	}
+
+	out.Layers = []inspectLayer{}
+	for _, l := range manifest.Layers {
+		out.Layers = append(out.Layers, inspectLayer{Digest: l.Digest, Size: l.Size, MediaType: l.MediaType})
+		out.TotalSize += l.Size
+	}
+
+	if manifest.Config.Digest != "" {
+		if local {
+			out.Config, err = client.GetLocalConfig(ctx, name, manifest.Config.Digest)
+		} else {
+			out.Config, err = client.GetConfig(ctx, image, manifest.Config.Digest)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch image config: %w", err)
+		}
+	}
+
+	if jsonOutput() {
+		return printJSON(out)
+	}
+	printInspectHeader(out)
+	printInspectLayers(out)
+	if out.Config != nil {
+		printInspectConfig(out.Config, out.Layers)
+	}
+	return nil
+}
+
+// mediaType is the manifest's media type, from the registry's Content-Type if
+// the manifest doesn't state it
+func mediaType(raw *registry.RawManifest, m *registry.Manifest) string {
+	if m.MediaType != "" {
+		return m.MediaType
+	}
+	return raw.MediaType
+}
+
+func printInspectHeader(out inspectOutput) {
+	fmt.Printf("🔍 %s (%s)\n", out.Image, out.Source)
+	fmt.Printf("Digest:      %s\n", out.Digest)
+	fmt.Printf("Media type:  %s\n", out.MediaType)
+	if len(out.Platforms) == 0 {
+		return
+	}
+
+	fmt.Printf("\n📚 Platforms (%d)\n", len(out.Platforms))
+	w := newTable()
+	fmt.Fprintln(w, "PLATFORM\tDIGEST\tMEDIA TYPE")
+	for _, p := range out.Platforms {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", p.Platform, shortDigest(p.Digest), p.MediaType)
+	}
+	w.Flush()
+}
+
+func printInspectLayers(out inspectOutput) {
+	if out.Platform != "" {
+		fmt.Printf("\nManifest:    %s (%s)\n", out.ManifestDigest, out.Platform)
+	}
+
+	fmt.Printf("\n📦 Layers (%d, %.1f MB)\n", len(out.Layers), float64(out.TotalSize)/(1024*1024))
+	w := newTable()
+	fmt.Fprintln(w, "DIGEST\tSIZE\tMEDIA TYPE")
+	for _, l := range out.Layers {
+		fmt.Fprintf(w, "%s\t%.1f MB\t%s\n", shortDigest(l.Digest), float64(l.Size)/(1024*1024), l.MediaType)
+	}
+	w.Flush()
+}
+
+func printInspectConfig(c *registry.ImageConfig, layers []inspectLayer) {
+	fmt.Println("\n⚙️  Config")
+	platform := registry.Platform{OS: c.OS, Architecture: c.Architecture, Variant: c.Variant}
+	fmt.Printf("Platform:    %s\n", platform.String())
+	if c.Created != nil {
+		fmt.Printf("Created:     %s\n", c.Created.Format(time.RFC3339))
+	}
+	if c.Config.User != "" {
+		fmt.Printf("User:        %s\n", c.Config.User)
+	}
+	if c.Config.WorkingDir != "" {
+		fmt.Printf("Workdir:     %s\n", c.Config.WorkingDir)
+	}
+	if len(c.Config.Entrypoint) > 0 {
+		fmt.Printf("Entrypoint:  %q\n", c.Config.Entrypoint)
+	}
+	if len(c.Config.Cmd) > 0 {
+		fmt.Printf("Cmd:         %q\n", c.Config.Cmd)
+	}
+	if len(c.Config.ExposedPorts) > 0 {
+		fmt.Printf("Ports:       %s\n", strings.Join(sortedKeys(c.Config.ExposedPorts), ", "))
+	}
+	if len(c.Config.Volumes) > 0 {
+		fmt.Printf("Volumes:     %s\n", strings.Join(sortedKeys(c.Config.Volumes), ", "))
+	}
+	if c.Config.StopSignal != "" {
+		fmt.Printf("Stop signal: %s\n", c.Config.StopSignal)
+	}
+
+	if len(c.Config.Env) > 0 {
+		fmt.Println("Env:")
+		for _, e := range c.Config.Env {
+			fmt.Printf("   %s\n", e)
+		}
+	}
+	if len(c.Config.Labels) > 0 {
+		fmt.Println("Labels:")
+		keys := make([]string, 0, len(c.Config.Labels))
+		for k := range c.Config.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("   %s=%s\n", k, c.Config.Labels[k])
+		}
+	}
+
+	if len(c.History) == 0 {
+		return
+	}
+	fmt.Printf("\n📜 History (%d steps)\n", len(c.History))
+	w := newTable()
+	fmt.Fprintln(w, "CREATED\tLAYER\tCREATED BY")
+	layer := 0
+	for _, h := range c.History {
+		created := "-"
+		if h.Created != nil {
+			created = h.Created.Format("2006-01-02")
+		}
+		// Steps that produced a layer match the manifest's layers in order
+		size := "-"
+		if !h.EmptyLayer {
+			if layer < len(layers) {
+				size = fmt.Sprintf("%.1f MB", float64(layers[layer].Size)/(1024*1024))
+			}
+			layer++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", created, size, truncate(createdBy(h.CreatedBy), 100))
+	}
+	w.Flush()
+}
+
+// createdBy tidies a history command: Dockerfile RUN steps are recorded as
+// "/bin/sh -c <cmd>" and other instructions as "/bin/sh -c #(nop) <instruction>"
+func createdBy(s string) string {
+	s = strings.TrimPrefix(s, "/bin/sh -c ")
+	if strings.HasPrefix(s, "#(nop) ") {
+		return strings.TrimSpace(strings.TrimPrefix(s, "#(nop) "))
+	}
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// truncate shortens s to at most n runes, marking the cut with "..."
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-3]) + "..."
+}
+
+// shortDigest abbreviates a digest for tables, like docker does for image IDs
+func shortDigest(digest string) string {
+	if i := strings.Index(digest, ":"); i >= 0 && len(digest) > i+13 {
+		return digest[:i+13]
+	}
+	return digest
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
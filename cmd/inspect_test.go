@@ -0,0 +1,37 @@
+package cmd
+
+import "testing"
+
+func TestCreatedBy(t *testing.T) {
+	cases := map[string]string{
+		`/bin/sh -c #(nop) ADD file:4b03b5f551e3fbdf47ec609712007327828f7530cc3455c43bbcdcaf449a75a9 in / `: `ADD file:4b03b5f551e3fbdf47ec609712007327828f7530cc3455c43bbcdcaf449a75a9 in /`,
+		`/bin/sh -c #(nop)  CMD ["nginx" "-g" "daemon off;"]`:                                               `CMD ["nginx" "-g" "daemon off;"]`,
+		"/bin/sh -c set -eux; \tapt-get update; \n\tapt-get install -y curl":                                "set -eux; apt-get update; apt-get install -y curl",
+		`RUN /bin/sh -c apk add --no-cache ca-certificates # buildkit`:                                      `RUN /bin/sh -c apk add --no-cache ca-certificates # buildkit`,
+		`ENV PATH=/usr/local/bin:/usr/bin`:                                                                  `ENV PATH=/usr/local/bin:/usr/bin`,
+	}
+	for in, want := range cases {
+		if got := createdBy(in); got != want {
+			t.Errorf("createdBy(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestTruncateKeepsRunesWhole(t *testing.T) {
+	cases := []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly10!", 10, "exactly10!"},
+		{"apt-get install -y curl", 10, "apt-get..."},
+		{"echo 'héllo wörld'", 10, "echo 'h..."},
+		{"echo 日本語のテキスト", 9, "echo 日..."},
+	}
+	for _, c := range cases {
+		if got := truncate(c.in, c.n); got != c.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", c.in, c.n, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/bundle"
+	"github.com/spf13/cobra"
+)
+
+var inventoryCmd = &cobra.Command{
+	Use:   "inventory",
+	Short: "List every manifest and blob held by the local registry",
+	Long: `Inventory writes a JSON description of everything the local registry holds.
+Run it on the disconnected side and carry the file over, so 'export --have'
+only bundles what is actually missing.
+
+Examples:
+  registry-mirror inventory > have.json`,
+	RunE: runInventory,
+}
+
+func init() {
+	rootCmd.AddCommand(inventoryCmd)
+}
+
+func runInventory(cmd *cobra.Command, args []string) error {
+	registryAddr := cfg.Registry
+
+	client := newRegistryClient()
+	inv, err := bundle.BuildInventory(context.Background(), client, registryAddr)
+	if err != nil {
+		return fmt.Errorf("failed to build inventory: %w", err)
+	}
+
+	// The inventory is JSON already, with or without --json
+	return printJSON(inv)
+}
@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Output modes, set with the persistent --json and --plain flags (or
+// REGISTRY_MIRROR_JSON / REGISTRY_MIRROR_PLAIN):
+//
+//   - --json writes one JSON document per command to stdout, as described in
+//     docs/json-output.md, and sends progress messages to stderr
+//   - --plain keeps the human-readable output but without emoji
+
+// stdout is the process's real standard output. In JSON mode os.Stdout is
+// pointed at stderr so only the JSON document lands here.
+var stdout = os.Stdout
+
+// plainPipe carries human output through stripEmoji in plain mode
+var (
+	plainPipe *os.File
+	plainDone chan struct{}
+)
+
+func jsonOutput() bool {
+	return viper.GetBool("json")
+}
+
+func plainOutput() bool {
+	return viper.GetBool("plain") && !jsonOutput()
+}
+
+// setupOutput redirects standard output for the selected mode before a command runs
+func setupOutput(cmd *cobra.Command, args []string) error {
+	switch {
+	case jsonOutput():
+		os.Stdout = os.Stderr
+	case plainOutput():
+		r, w, err := os.Pipe()
+		if err != nil {
+			return err
+		}
+		plainPipe, plainDone = w, make(chan struct{})
+		os.Stdout = w
+		go func() {
+			io.Copy(&emojiStripper{w: stdout}, r)
+			close(plainDone)
+		}()
+	}
+	return nil
+}
+
+// finishOutput flushes plain output and restores standard output
+func finishOutput() {
+	if plainPipe != nil {
+		plainPipe.Close()
+		<-plainDone
+		plainPipe = nil
+	}
+	os.Stdout = stdout
+}
+
+// jsonPrinted records that the command wrote its document, so a failing
+// command that already reported its results doesn't add an error document
+var jsonPrinted bool
+
+// printJSON writes a command's JSON document to standard output
+func printJSON(v interface{}) error {
+	jsonPrinted = true
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// nonNil turns a nil slice into an empty one, so it's encoded as [] rather than null
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
+
+// errorOutput is the JSON document of a command that failed
+type errorOutput struct {
+	Error         string `json:"error"`
+	ErrorCategory string `json:"error_category,omitempty"`
+}
+
+// table is a tabwriter for command output. In plain mode cells lose their
+// emoji before columns are aligned.
+type table struct {
+	*tabwriter.Writer
+}
+
+func newTable() *table {
+	return &table{tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)}
+}
+
+func (t *table) Write(p []byte) (int, error) {
+	if !plainOutput() {
+		return t.Writer.Write(p)
+	}
+	if _, err := t.Writer.Write([]byte(stripEmoji(string(p)))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// emojiStripper removes emoji from a stream, holding back a rune split across
+// writes and dropping spaces after an emoji that ended the previous write
+type emojiStripper struct {
+	w          io.Writer
+	tail       []byte
+	afterEmoji bool
+}
+
+func (s *emojiStripper) Write(p []byte) (int, error) {
+	data := append(s.tail, p...)
+	end := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				end = i
+			}
+			break
+		}
+	}
+	s.tail = append([]byte(nil), data[end:]...)
+
+	var out string
+	out, s.afterEmoji = trimEmoji(string(data[:end]), s.afterEmoji)
+	if _, err := io.WriteString(s.w, out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// stripEmoji drops emoji along with the spaces that separate them from the text
+func stripEmoji(s string) string {
+	out, _ := trimEmoji(s, false)
+	return out
+}
+
+// trimEmoji is stripEmoji for a piece of a longer text: afterEmoji says the
+// text before it ended in an emoji, and is returned for the text after it
+func trimEmoji(s string, afterEmoji bool) (string, bool) {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case isEmoji(r):
+			afterEmoji = true
+			continue
+		case afterEmoji && r == ' ':
+			continue
+		}
+		afterEmoji = false
+		b.WriteRune(r)
+	}
+	return b.String(), afterEmoji
+}
+
+func isEmoji(r rune) bool {
+	switch {
+	case r >= 0x1F000 && r <= 0x1FAFF, // pictographs, emoticons, transport, ...
+		r >= 0x2600 && r <= 0x27BF, // miscellaneous symbols and dingbats (✅ ❌ ⚠ ✨)
+		r >= 0x2300 && r <= 0x23FF, // technical symbols (⏳ ⏱ ⏸)
+		r >= 0x2B00 && r <= 0x2BFF, // arrows and stars (⭐)
+		r == 0xFE0F, r == 0x200D:   // emoji presentation selector and joiner
+		return true
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStripEmoji(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"✅ Successfully synced nginx:latest\n", "Successfully synced nginx:latest\n"},
+		{"⚠️  Cache policy check failed", "Cache policy check failed"}, // with variation selector
+		{"🗑️  Evicted redis:7", "Evicted redis:7"},                     // pictograph + selector
+		{"👨‍💻 dev", "dev"},                                             // joined sequence
+		{"⏳ waiting ⭐ starred", "waiting starred"},                     // technical symbols, stars
+		{"3 images, 1.5 MB", "3 images, 1.5 MB"},                       // nothing to strip
+		{"café → naïve — ok", "café → naïve — ok"},                     // other non-ASCII stays
+		{"PLATFORM\tDIGEST\n📦 linux/amd64\tsha256:abc\n", "PLATFORM\tDIGEST\nlinux/amd64\tsha256:abc\n"},
+	}
+	for _, c := range cases {
+		if got := stripEmoji(c.in); got != c.want {
+			t.Errorf("stripEmoji(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestEmojiStripperAcrossWrites(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"🚀 Syncing nginx:latest\n", "Syncing nginx:latest\n"},
+		{"⚠️  low disk\n✅ done\n", "low disk\ndone\n"},
+		{"plain text\n", "plain text\n"},
+	}
+	for _, c := range cases {
+		// Every split point, including inside a multi-byte emoji and
+		// between an emoji and its spaces
+		for i := 0; i <= len(c.in); i++ {
+			var out strings.Builder
+			s := &emojiStripper{w: &out}
+			for _, part := range []string{c.in[:i], c.in[i:]} {
+				if n, err := s.Write([]byte(part)); err != nil || n != len(part) {
+					t.Fatalf("Write(%q) = %d, %v", part, n, err)
+				}
+			}
+			if got := out.String(); got != c.want {
+				t.Errorf("%q split at byte %d: got %q, want %q", c.in, i, got, c.want)
+			}
+		}
+	}
+
+	// A rune split across writes is held back, not written as two halves
+	var out strings.Builder
+	s := &emojiStripper{w: &out}
+	rocket := []byte("🚀")
+	s.Write([]byte("a"))
+	s.Write(rocket[:2])
+	if got := out.String(); got != "a" {
+		t.Errorf("Expected the partial rune to be held back, got %q", got)
+	}
+	s.Write(append(rocket[2:], " b"...))
+	if got := out.String(); got != "ab" {
+		t.Errorf("Expected %q, got %q", "ab", got)
+	}
+}
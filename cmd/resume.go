@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/cache"
+	"github.com/saurabh12nxf/registry-mirror/internal/config"
+	"github.com/saurabh12nxf/registry-mirror/internal/mirror"
+	"github.com/saurabh12nxf/registry-mirror/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var resumeCmd = &cobra.Command{
+	Use:   "resume [image...]",
+	Short: "Restart syncs that were interrupted",
+	Long: `Resume restarts every sync whose process was killed or crashed before it
+finished, or only the given images. Layers that already made it to the local
+registry are skipped, so only the rest is transferred.
+
+A sync counts as interrupted once it has gone a minute without a heartbeat,
+or straight away when it was stopped with Ctrl-C.`,
+	RunE: runResume,
+}
+
+func init() {
+	rootCmd.AddCommand(resumeCmd)
+
+	resumeCmd.Flags().Int("parallel", config.Default().Parallel, "number of parallel layer downloads")
+	resumeCmd.Flags().Bool("dry-run", false, "list interrupted syncs without resuming them")
+	resumeCmd.Flags().Bool("ignore-limit", false, "resume even if an image doesn't fit within the cache limits")
+	bindFlag(resumeCmd, "parallel", "parallel")
+}
+
+// resumeOutput is the JSON document of 'resume'
+type resumeOutput struct {
+	Interrupted []storage.SyncRecord `json:"interrupted"`
+	Results     []syncOutcome        `json:"results"`
+}
+
+func runResume(cmd *cobra.Command, args []string) error {
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	ignoreLimit, _ := cmd.Flags().GetBool("ignore-limit")
+	registry := cfg.Registry
+
+	db, err := openDB()
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	if _, err := mirror.NewTracker(db).RecoverInterrupted(); err != nil {
+		return err
+	}
+	interrupted, err := db.GetInterruptedSyncs()
+	if err != nil {
+		return fmt.Errorf("failed to fetch interrupted syncs: %w", err)
+	}
+	interrupted = filterSyncs(interrupted, args)
+
+	var out resumeOutput
+	if jsonOutput() {
+		out.Interrupted = nonNil(interrupted)
+		out.Results = []syncOutcome{}
+		defer func() { printJSON(out) }()
+	}
+
+	if len(interrupted) == 0 {
+		fmt.Println("✨ No interrupted syncs to resume.")
+		return nil
+	}
+
+	fmt.Printf("⚠️  %d interrupted sync(s)\n", len(interrupted))
+	for _, r := range interrupted {
+		fmt.Printf("   - %s (%s, %s ago)\n", r.Image, layerSummary(db, r.ID), time.Since(r.Timestamp).Round(time.Second))
+	}
+	if dryRun {
+		return nil
+	}
+
+	session, err := newSyncSession(db, registry, cfg.Parallel, ignoreLimit)
+	if err != nil {
+		return err
+	}
+	stop := session.interruptOnSignal()
+	defer stop()
+	defer applyRetention(db)
+
+	var failed int
+	for i, r := range interrupted {
+		fmt.Printf("\n[%d/%d] 🔄 Resuming %s...\n", i+1, len(interrupted), r.Image)
+
+		result, err := session.sync(r.Image, false, false)
+		if jsonOutput() {
+			out.Results = append(out.Results, session.outcome(r.Image, err))
+		}
+		if errors.Is(err, errInterrupted) {
+			return err
+		}
+		var spaceErr *cache.SpaceError
+		switch {
+		case errors.As(err, &spaceErr):
+			fmt.Printf("⏸️  Deferred %s: %v\n", r.Image, err)
+		case err != nil:
+			fmt.Printf("❌ Failed to resume %s: %v\n", r.Image, err)
+			failed++
+		default:
+			fmt.Printf("✅ Resumed %s (%d of %d layers were already there)\n", r.Image, result.LayersSkipped, result.Layers)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d syncs could not be resumed", failed, len(interrupted))
+	}
+	return nil
+}
+
+// filterSyncs keeps the records of the given images, or all of them when none are given
+func filterSyncs(records []storage.SyncRecord, images []string) []storage.SyncRecord {
+	if len(images) == 0 {
+		return records
+	}
+	wanted := make(map[string]bool)
+	for _, img := range images {
+		wanted[img] = true
+	}
+
+	var kept []storage.SyncRecord
+	for _, r := range records {
+		if wanted[r.Image] {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// layerSummary describes how far a running or interrupted sync got
+func layerSummary(db storage.DB, id int) string {
+	layers, err := db.GetSyncLayers(id)
+	if err != nil || len(layers) == 0 {
+		return "no layers transferred"
+	}
+	var done int
+	var bytes int64
+	for _, l := range layers {
+		if l.Status == mirror.LayerDone || l.Status == mirror.LayerSkipped {
+			done++
+			bytes += l.Size
+		}
+	}
+	return fmt.Sprintf("%d layers, %.1f MB in place", done, float64(bytes)/(1024*1024))
+}
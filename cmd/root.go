@@ -1,15 +1,25 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 
+	"github.com/saurabh12nxf/registry-mirror/internal/config"
+	"github.com/saurabh12nxf/registry-mirror/internal/mirror"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var cfgFile string
 
+// cfg is the loaded configuration, set before any command runs
+var cfg *config.Config
+
+// configErr is why the config file couldn't be read, if it exists
+var configErr error
+
 var rootCmd = &cobra.Command{
 	Use:   "registry-mirror",
 	Short: "A smart Docker registry mirroring tool",
@@ -19,18 +29,60 @@ from Docker Hub to your local registry, dramatically speeding up image pulls.
 Born from frustration with slow Docker image pulls on home networks,
 this tool reduces pull times from minutes to seconds by maintaining
 a smart local cache of frequently-used images.`,
+	PersistentPreRunE: prepare,
+}
+
+// prepare loads and validates the configuration and sets up output before a command runs
+func prepare(cmd *cobra.Command, args []string) error {
+	if err := bindFlags(cmd); err != nil {
+		return err
+	}
+	if err := setupOutput(cmd, args); err != nil {
+		return err
+	}
+
+	if configErr != nil {
+		return configErr
+	}
+	var err error
+	if cfg, err = config.Load(viper.GetViper()); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w (see 'registry-mirror config validate')", err)
+	}
+	return nil
 }
 
 func Execute() error {
-	return rootCmd.Execute()
+	err := rootCmd.Execute()
+	finishOutput()
+	if err != nil && jsonOutput() && !jsonPrinted {
+		out := errorOutput{Error: err.Error()}
+		if category := mirror.Classify(err); category != mirror.CategoryUnknown {
+			out.ErrorCategory = string(category)
+		}
+		printJSON(out)
+	}
+	return err
 }
 
 func init() {
 	cobra.OnInitialize(initConfig)
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.registry-mirror.yaml)")
-	rootCmd.PersistentFlags().Bool("json", false, "output in JSON format")
-	rootCmd.PersistentFlags().StringP("registry", "r", "localhost:5000", "local registry address")
+	rootCmd.PersistentFlags().String("profile", "", "profile of the config file to use")
+	rootCmd.PersistentFlags().Bool("json", false, "output in JSON format (see docs/json-output.md), progress goes to stderr")
+	rootCmd.PersistentFlags().Bool("plain", false, "output without emoji")
+	rootCmd.PersistentFlags().StringP("registry", "r", config.Default().Registry, "local registry address")
+	rootCmd.PersistentFlags().String("db", "", "database file or postgres:// DSN (default is $HOME/.registry-mirror.db)")
+	bindFlag(rootCmd, "json", "json")
+	bindFlag(rootCmd, "plain", "plain")
+	bindFlag(rootCmd, "registry", "registry")
+	bindFlag(rootCmd, "db", "db")
+	bindFlag(rootCmd, "profile", "profile")
+	// The profile is applied while the config file is read, before the other flags are bound
+	viper.BindPFlag("profile", rootCmd.PersistentFlags().Lookup("profile"))
 }
 
 func initConfig() {
@@ -44,15 +96,26 @@ func initConfig() {
 		}
 
 		viper.AddConfigPath(home)
-		viper.SetConfigType("yaml")
 		viper.SetConfigName(".registry-mirror")
 	}
+	// Whatever its extension, e.g. .registry-mirror.yaml.example
+	viper.SetConfigType("yaml")
 
-	viper.SetEnvPrefix("REGISTRY_MIRROR")
+	config.SetDefaults(viper.GetViper())
+	viper.SetEnvPrefix(config.EnvPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.AutomaticEnv()
 
-	if err := viper.ReadInConfig(); err == nil {
-		// Config file found and loaded successfully
-		// We don't print this to avoid cluttering output
+	// Running without a config file is fine, a broken one isn't
+	if err := viper.ReadInConfig(); err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		if !errors.As(err, &notFound) {
+			configErr = fmt.Errorf("failed to read config file: %w", err)
+			return
+		}
+	}
+
+	if err := config.ApplyProfile(viper.GetViper(), viper.GetString("profile")); err != nil {
+		configErr = err
 	}
 }
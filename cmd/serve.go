@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/cache"
+	"github.com/saurabh12nxf/registry-mirror/internal/config"
+	"github.com/saurabh12nxf/registry-mirror/internal/server"
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run a pull-through registry mirror",
+	Long: `Serve runs a read-only Docker registry that answers pulls from the local blob
+cache and fetches anything missing from Docker Hub on the fly. No separate
+registry:2 and no prior sync are needed, and every pull is recorded for analytics.
+
+Examples:
+  registry-mirror serve --listen :5000
+  docker pull localhost:5000/library/nginx`,
+	RunE: runServe,
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().String("listen", config.Default().Serve.Listen, "address to listen on")
+	serveCmd.Flags().Duration("tag-ttl", config.Default().Serve.TagTTL, "how long a cached tag is served before it is revalidated upstream")
+	serveCmd.Flags().Bool("prefetch", false, "fetch images usually pulled together with the one being pulled")
+	bindFlag(serveCmd, "listen", "serve.listen")
+	bindFlag(serveCmd, "tag-ttl", "serve.tag_ttl")
+	bindFlag(serveCmd, "prefetch", "serve.prefetch")
+}
+
+func runServe(cmd *cobra.Command, args []string) error {
+	listen, tagTTL, prefetch := cfg.Serve.Listen, cfg.Serve.TagTTL, cfg.Serve.Prefetch
+
+	db, err := openDB()
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	store, err := openBlobStore()
+	if err != nil {
+		return fmt.Errorf("failed to open blob store: %w", err)
+	}
+
+	srv := server.New(newRegistryClient(), store, db, tagTTL)
+
+	if prefetch {
+		assoc := cache.NewAssociations(db, cache.DefaultSessionGap, 2, 0.5)
+		prefetcher := cache.NewPrefetcher(assoc, func(image string) error {
+			return srv.Warm(context.Background(), image)
+		})
+		srv.SetPullHook(prefetcher.Pulled)
+	}
+
+	fmt.Printf("🌐 Serving pull-through mirror on %s (tag TTL %s)\n", listen, tagTTL)
+	fmt.Printf("   docker pull <host>%s/library/nginx\n", listen)
+	return http.ListenAndServe(listen, srv)
+}
@@ -2,10 +2,9 @@ package cmd
 
 import (
 	"fmt"
-	"os"
-	"text/tabwriter"
 	"time"
 
+	"github.com/saurabh12nxf/registry-mirror/internal/mirror"
 	"github.com/saurabh12nxf/registry-mirror/internal/storage"
 	"github.com/spf13/cobra"
 )
@@ -20,49 +19,134 @@ var statusCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(statusCmd)
 	statusCmd.Flags().IntP("limit", "n", 10, "number of recent entries to show")
+	statusCmd.Flags().String("image", "", "only show syncs of this image")
+	statusCmd.Flags().Bool("failed", false, "only show failed syncs")
+	statusCmd.Flags().String("category", "", "only show failures of this category (auth, not-found, rate-limited, network, digest-mismatch, push-rejected, unknown)")
 }
 
 func runStatus(cmd *cobra.Command, args []string) error {
 	limit, _ := cmd.Flags().GetInt("limit")
+	image, _ := cmd.Flags().GetString("image")
+	failed, _ := cmd.Flags().GetBool("failed")
+	category, _ := cmd.Flags().GetString("category")
 
-	db, err := storage.NewDB()
+	filter := storage.SyncFilter{Image: image, ErrorCategory: category, Limit: limit}
+	if category != "" {
+		if !validCategory(category) {
+			return fmt.Errorf("unknown error category %q", category)
+		}
+		failed = true
+	}
+	if failed {
+		filter.Status = "failed"
+	}
+
+	db, err := openDB()
 	if err != nil {
 		return fmt.Errorf("failed to open database: %w", err)
 	}
 	defer db.Close()
 
-	records, err := db.GetRecentSyncs(limit)
+	records, err := db.GetSyncs(filter)
 	if err != nil {
 		return fmt.Errorf("failed to fetch status: %w", err)
 	}
 
+	pinned, err := pinnedImages(db)
+	if err != nil {
+		return fmt.Errorf("failed to fetch pinned images: %w", err)
+	}
+
+	if jsonOutput() {
+		return printJSON(statusOutput{Syncs: nonNil(records), Pinned: nonNil(pinned)})
+	}
+
 	if len(records) == 0 {
 		fmt.Println("No sync activity recorded yet.")
+		printPinned(pinned)
 		return nil
 	}
 
 	fmt.Printf("🔍 Recent Sync Activity (Last %d)\n\n", limit)
 
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
-	fmt.Fprintln(w, "IMAGE\tSTATUS\tSIZE\tDURATION\tTIME")
+	w := newTable()
+	fmt.Fprintln(w, "IMAGE\tSTATUS\tTRANSFERRED\tSKIPPED\tLAYERS\tDURATION\tTIME")
 
+	var failures []storage.SyncRecord
 	for _, r := range records {
 		timeAgo := time.Since(r.Timestamp).Round(time.Second)
-		sizeMB := float64(r.Bytes) / (1024 * 1024)
 
 		statusIcon := "✅"
-		if r.Status != "completed" {
+		status := r.Status
+		switch r.Status {
+		case "completed":
+		case "evicted":
+			statusIcon = "🗑️"
+		case storage.StatusRunning, storage.StatusInterrupted:
+			statusIcon = "⏳"
+			if r.Status == storage.StatusInterrupted {
+				statusIcon = "⚠️"
+			}
+			status += ", " + layerSummary(db, r.ID)
+			if r.Status == storage.StatusRunning {
+				status += fmt.Sprintf(", heartbeat %s ago", time.Since(r.Heartbeat).Round(time.Second))
+			}
+		default:
 			statusIcon = "❌"
+			if r.ErrorCategory != "" {
+				status += " (" + r.ErrorCategory + ")"
+			}
+			failures = append(failures, r)
 		}
 
-		fmt.Fprintf(w, "%s\t%s %s\t%.1f MB\t%.2fs\t%s ago\n",
+		layers := "-"
+		if r.Layers > 0 {
+			layers = fmt.Sprintf("%d (%d skipped)", r.Layers, r.LayersSkipped)
+		}
+
+		fmt.Fprintf(w, "%s\t%s %s\t%.1f MB\t%.1f MB\t%s\t%.2fs\t%s ago\n",
 			r.Image,
-			statusIcon, r.Status,
-			sizeMB,
+			statusIcon, status,
+			float64(r.Bytes)/(1024*1024),
+			float64(r.BytesSkipped)/(1024*1024),
+			layers,
 			r.Duration,
 			timeAgo)
 	}
 	w.Flush()
 
+	if len(failures) > 0 {
+		fmt.Printf("\n❌ Errors\n")
+		for _, r := range failures {
+			fmt.Printf("   %s: %s\n", r.Image, r.Error)
+		}
+	}
+
+	printPinned(pinned)
 	return nil
 }
+
+// statusOutput is the JSON document of 'status'
+type statusOutput struct {
+	Syncs  []storage.SyncRecord `json:"syncs"`
+	Pinned []string             `json:"pinned"`
+}
+
+func validCategory(category string) bool {
+	for _, c := range mirror.Categories {
+		if string(c) == category {
+			return true
+		}
+	}
+	return false
+}
+
+func printPinned(pinned []string) {
+	if len(pinned) == 0 {
+		return
+	}
+	fmt.Printf("\n📌 Pinned Images (never evicted)\n")
+	for _, img := range pinned {
+		fmt.Printf("   - %s\n", img)
+	}
+}
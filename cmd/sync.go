@@ -1,11 +1,18 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"time"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/saurabh12nxf/registry-mirror/internal/blobstore"
 	"github.com/saurabh12nxf/registry-mirror/internal/cache"
+	"github.com/saurabh12nxf/registry-mirror/internal/config"
 	"github.com/saurabh12nxf/registry-mirror/internal/mirror"
+	"github.com/saurabh12nxf/registry-mirror/internal/registry"
 	"github.com/saurabh12nxf/registry-mirror/internal/storage"
 	"github.com/spf13/cobra"
 )
@@ -27,47 +34,197 @@ func init() {
 	rootCmd.AddCommand(syncCmd)
 
 	syncCmd.Flags().BoolP("force", "f", false, "force re-sync even if image exists")
-	syncCmd.Flags().Int("parallel", 3, "number of parallel layer downloads")
+	syncCmd.Flags().Int("parallel", config.Default().Parallel, "number of parallel layer downloads")
+	syncCmd.Flags().Bool("offline", false, "re-push from the local blob cache without contacting Docker Hub")
+	syncCmd.Flags().Bool("ignore-limit", false, "sync even if the image doesn't fit within the cache limits")
+	bindFlag(syncCmd, "parallel", "parallel")
+}
+
+// openBlobStore opens the blob store used to stage layers between upstream and the local registry
+func openBlobStore() (*blobstore.Store, error) {
+	dir, err := blobstore.DefaultPath()
+	if err != nil {
+		return nil, err
+	}
+	return blobstore.Open(dir)
+}
+
+// newRegistryClient returns a client for the local registry that pulls from
+// Docker Hub with the configured account
+func newRegistryClient() *registry.Client {
+	client := registry.NewClient(cfg.Registry)
+	client.SetUpstreamCredentials(cfg.Upstream.Username, cfg.Upstream.Password)
+	return client
+}
+
+// errInterrupted is returned by a command whose syncs were stopped with Ctrl-C or SIGTERM
+var errInterrupted = errors.New("interrupted, run 'registry-mirror resume' to pick up where this left off")
+
+// syncSession is what the syncs of one command run share: the database,
+// the tracker recording them as running, and a syncer staging through the blob store
+type syncSession struct {
+	ctx      context.Context
+	db       storage.DB
+	tracker  *mirror.Tracker
+	syncer   *mirror.Syncer
+	cacheMgr *cache.Manager
+}
+
+// newSyncSession prepares syncing to the given registry. Unless ignoreLimit
+// is set, images that don't fit the cache are refused before transferring anything.
+func newSyncSession(db storage.DB, registry string, parallel int, ignoreLimit bool) (*syncSession, error) {
+	store, err := openBlobStore()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob store: %w", err)
+	}
+
+	cacheMgr, err := newCacheManager(db)
+	if err != nil {
+		return nil, err
+	}
+
+	tracker := mirror.NewTracker(db)
+	syncer := mirror.NewSyncer(registry, parallel)
+	syncer.Client().SetUpstreamCredentials(cfg.Upstream.Username, cfg.Upstream.Password)
+	syncer.UseBlobStore(store)
+	syncer.UseDB(db)
+	syncer.SetLayerHook(tracker.TrackLayer)
+	if !ignoreLimit {
+		syncer.SetPreflight(cacheMgr.CheckFits)
+	}
+	cacheMgr.UseRegistry(syncer.Client())
+
+	return &syncSession{ctx: context.Background(), db: db, tracker: tracker, syncer: syncer, cacheMgr: cacheMgr}, nil
+}
+
+// sync runs and records one sync. A *cache.SpaceError means the image was
+// refused before anything was transferred, and leaves no record. errInterrupted
+// means the session was stopped and the sync is recorded as interrupted.
+func (s *syncSession) sync(image string, force, offline bool) (*mirror.SyncResult, error) {
+	if s.ctx.Err() != nil {
+		return nil, errInterrupted
+	}
+	if err := s.tracker.TrackSyncStart(image); err != nil {
+		return nil, err
+	}
+
+	var result *mirror.SyncResult
+	var err error
+	if offline {
+		result, err = s.syncer.SyncFromCache(s.ctx, image)
+	} else {
+		result, err = s.syncer.Sync(s.ctx, image, force)
+	}
+
+	var spaceErr *cache.SpaceError
+	switch {
+	case err != nil && s.ctx.Err() != nil:
+		// Whatever failed, it failed because the transfers were cancelled
+		s.tracker.TrackSyncInterrupted(result)
+		return result, errInterrupted
+	case errors.As(err, &spaceErr):
+		s.tracker.TrackSyncCancelled(image)
+	case err != nil:
+		s.tracker.TrackSyncError(result, err)
+	default:
+		s.tracker.TrackSyncComplete(result)
+	}
+	return result, err
+}
+
+// interruptOnSignal cancels the session's syncs when the process is stopped
+// with Ctrl-C or SIGTERM. The running sync is recorded as interrupted and the
+// command returns errInterrupted, so it can clean up and still report. A second
+// signal exits straight away. The returned function stops listening.
+func (s *syncSession) interruptOnSignal() (stop func()) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	s.ctx = ctx
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return stop
+}
+
+// syncOutcome is how one of several syncs went, for JSON output
+type syncOutcome struct {
+	Image         string              `json:"image"`
+	Status        string              `json:"status"` // completed, failed, deferred or skipped
+	Error         string              `json:"error,omitempty"`
+	ErrorCategory string              `json:"error_category,omitempty"`
+	Sync          *storage.SyncRecord `json:"sync,omitempty"`
+}
+
+// outcome describes the result of session.sync
+func (s *syncSession) outcome(image string, err error) syncOutcome {
+	out := syncOutcome{Image: image, Status: "completed"}
+	var spaceErr *cache.SpaceError
+	switch {
+	case errors.Is(err, errInterrupted):
+		out.Status = "interrupted"
+	case errors.As(err, &spaceErr):
+		out.Status = "deferred"
+	case errors.Is(err, mirror.ErrAlreadyRunning):
+		out.Status = "skipped"
+	case err != nil:
+		out.Status = "failed"
+		out.ErrorCategory = string(mirror.Classify(err))
+	}
+	if err != nil {
+		out.Error = err.Error()
+	}
+	if out.Status == "completed" || out.Status == "failed" || out.Status == "interrupted" {
+		out.Sync, _ = s.db.GetLatestSync(image)
+	}
+	return out
 }
 
 func runSync(cmd *cobra.Command, args []string) error {
 	image := args[0]
 	force, _ := cmd.Flags().GetBool("force")
-	parallel, _ := cmd.Flags().GetInt("parallel")
-	registry, _ := cmd.Flags().GetString("registry")
+	offline, _ := cmd.Flags().GetBool("offline")
+	ignoreLimit, _ := cmd.Flags().GetBool("ignore-limit")
+	registry := cfg.Registry
 
 	fmt.Printf("🔄 Syncing %s to %s...\n", image, registry)
 
 	// Init DB and Tracker
-	db, err := storage.NewDB()
+	db, err := openDB()
 	if err != nil {
 		return fmt.Errorf("failed to init database: %w", err)
 	}
 	defer db.Close()
 
-	tracker := mirror.NewTracker(db)
-	start := time.Now()
-
-	syncer := mirror.NewSyncer(registry, parallel)
-
-	err = syncer.Sync(image, force)
-	duration := time.Since(start)
-
+	session, err := newSyncSession(db, registry, cfg.Parallel, ignoreLimit)
 	if err != nil {
-		tracker.TrackSyncError(image, err)
-		return fmt.Errorf("sync failed: %w", err)
+		return err
+	}
+	stop := session.interruptOnSignal()
+	defer stop()
+	defer applyRetention(db)
+
+	_, err = session.sync(image, force, offline)
+	var spaceErr *cache.SpaceError
+	if errors.As(err, &spaceErr) {
+		// Nothing was transferred, so this isn't a failed sync
+		return fmt.Errorf("not enough cache space: %w (or pass --ignore-limit)", err)
+	}
+	if errors.Is(err, mirror.ErrAlreadyRunning) || errors.Is(err, errInterrupted) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("sync failed (%s): %w", mirror.Classify(err), err)
 	}
-
-	// Calculate total bytes (simplified, in real app we'd get this from syncer)
-	tracker.TrackSyncComplete(image, 0, duration)
 
 	fmt.Printf("✅ Successfully synced %s\n", image)
 
-	// Check cache policy (Default: 10GB limit)
-	cacheMgr := cache.NewManager(db, 10000, cache.PolicyLRU)
-	if err := cacheMgr.EnforcePolicy(); err != nil {
+	// Check cache policy (limit from cache.max_size_mb)
+	if err := session.cacheMgr.EnforcePolicy(); err != nil {
 		fmt.Printf("⚠️  Cache policy check failed: %v\n", err)
 	}
 
+	if jsonOutput() {
+		return printJSON(session.outcome(image, nil))
+	}
 	return nil
 }
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/cache"
+	"github.com/saurabh12nxf/registry-mirror/internal/config"
+	"github.com/saurabh12nxf/registry-mirror/internal/server"
+	"github.com/spf13/cobra"
+)
+
+var webhookCmd = &cobra.Command{
+	Use:   "webhook",
+	Short: "Receive pull notifications from a registry:2 instance",
+	Long: `Webhook listens for registry:2 notification events and records every image
+pull, so 'auto' can predict from what your team actually uses.
+
+Add this to the registry's config.yml:
+
+  notifications:
+    endpoints:
+      - name: registry-mirror
+        url: http://<this-host>:5001/events
+        timeout: 1s
+        threshold: 5
+        backoff: 10s
+
+Examples:
+  registry-mirror webhook --listen :5001`,
+	RunE: runWebhook,
+}
+
+func init() {
+	rootCmd.AddCommand(webhookCmd)
+
+	webhookCmd.Flags().String("listen", config.Default().Webhook.Listen, "address to listen on")
+	webhookCmd.Flags().String("path", config.Default().Webhook.Path, "URL path the registry posts events to")
+	webhookCmd.Flags().Bool("prefetch", false, "sync images usually pulled together with the one being pulled")
+	bindFlag(webhookCmd, "listen", "webhook.listen")
+	bindFlag(webhookCmd, "path", "webhook.path")
+	bindFlag(webhookCmd, "prefetch", "webhook.prefetch")
+}
+
+func runWebhook(cmd *cobra.Command, args []string) error {
+	listen, path, prefetch := cfg.Webhook.Listen, cfg.Webhook.Path, cfg.Webhook.Prefetch
+	registryAddr := cfg.Registry
+
+	db, err := openDB()
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	handler := server.NewWebhookHandler(db)
+
+	if prefetch {
+		// Prefetches are held to the cache limits like any other sync
+		session, err := newSyncSession(db, registryAddr, cfg.Parallel, false)
+		if err != nil {
+			return err
+		}
+
+		assoc := cache.NewAssociations(db, cache.DefaultSessionGap, 2, 0.5)
+		prefetcher := cache.NewPrefetcher(assoc, func(image string) error {
+			_, err := session.sync(image, false, false)
+			var spaceErr *cache.SpaceError
+			if errors.As(err, &spaceErr) {
+				fmt.Printf("⏸️  Deferred prefetch of %s: %v\n", image, err)
+				return nil
+			}
+			return err
+		})
+		handler.SetPullHook(prefetcher.Pulled)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, handler)
+
+	fmt.Printf("👂 Listening for registry notifications on %s%s\n", listen, path)
+	return http.ListenAndServe(listen, mux)
+}
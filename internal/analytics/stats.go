@@ -2,23 +2,34 @@ package analytics
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/saurabh12nxf/registry-mirror/internal/storage"
 )
 
 type Analyzer struct {
-	db *storage.DB
+	db storage.DB
 }
 
-func NewAnalyzer(db *storage.DB) *Analyzer {
+func NewAnalyzer(db storage.DB) *Analyzer {
 	return &Analyzer{db: db}
 }
 
 type Report struct {
-	TotalImages    int
-	TotalBandwidth string
-	TimeSaved      string
-	AvgSpeed       string
+	TotalImages    int    `json:"unique_images"`
+	TotalBandwidth string `json:"-"`
+	TotalSkipped   string `json:"-"`
+	TimeSaved      string `json:"-"`
+	AvgSpeed       string `json:"-"`
+	FailedSyncs    int    `json:"failed_syncs"`
+	// FailuresByCategory counts failed syncs of the last 30 days by error category
+	FailuresByCategory map[string]int `json:"failures_by_category"`
+
+	// The figures above unformatted, for machine-readable output
+	BytesTransferred int64   `json:"bytes_transferred"`
+	BytesSkipped     int64   `json:"bytes_skipped"`
+	TimeSavedSeconds float64 `json:"estimated_time_saved_seconds"`
+	AvgSpeedMBps     float64 `json:"average_throughput_mb_per_second"`
 }
 
 func (a *Analyzer) GenerateReport() (*Report, error) {
@@ -46,10 +57,22 @@ func (a *Analyzer) GenerateReport() (*Report, error) {
 		avgSpeed = totalMB / stats.TotalDuration
 	}
 
+	failures, err := a.db.GetErrorCounts(time.Now().AddDate(0, 0, -30))
+	if err != nil {
+		return nil, err
+	}
+
 	return &Report{
-		TotalImages:    stats.UniqueImages,
-		TotalBandwidth: fmt.Sprintf("%.2f GB", totalMB/1024),
-		TimeSaved:      fmt.Sprintf("%.1f min", timeSaved/60),
-		AvgSpeed:       fmt.Sprintf("%.1f MB/s", avgSpeed),
+		TotalImages:        stats.UniqueImages,
+		TotalBandwidth:     fmt.Sprintf("%.2f GB", totalMB/1024),
+		TotalSkipped:       fmt.Sprintf("%.2f GB", float64(stats.TotalBytesSkipped)/(1024*1024*1024)),
+		TimeSaved:          fmt.Sprintf("%.1f min", timeSaved/60),
+		AvgSpeed:           fmt.Sprintf("%.1f MB/s", avgSpeed),
+		FailedSyncs:        stats.FailedCount,
+		FailuresByCategory: failures,
+		BytesTransferred:   stats.TotalBytes,
+		BytesSkipped:       stats.TotalBytesSkipped,
+		TimeSavedSeconds:   timeSaved,
+		AvgSpeedMBps:       avgSpeed,
 	}, nil
 }
@@ -0,0 +1,465 @@
+package blobstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/filelock"
+	"github.com/saurabh12nxf/registry-mirror/internal/registry"
+)
+
+// ErrDigestMismatch is returned when content doesn't hash to the digest it was stored under
+var ErrDigestMismatch = errors.New("digest mismatch")
+
+// gcGrace is how old an unreferenced blob must be before GC removes it, so
+// content written just before its references are recorded isn't lost
+const gcGrace = time.Hour
+
+// Store is an on-disk content-addressable blob cache laid out as
+// <root>/sha256/<hex>. Blobs are reference counted by the images that use
+// them so unreferenced content can be garbage collected.
+//
+// The references live in <root>/refs.json, which several processes (serve
+// and sync, say) may share: changes to it are made under a file lock after
+// re-reading it.
+type Store struct {
+	root string
+
+	mu     sync.Mutex
+	images map[string]ImageRefs
+	// loaded is the refs.json images was read from, to notice when another
+	// process replaces it
+	loaded os.FileInfo
+}
+
+// ImageRefs records the manifest and blobs an image needs from the store
+type ImageRefs struct {
+	Manifest  string    `json:"manifest"`
+	MediaType string    `json:"mediaType"`
+	Blobs     []string  `json:"blobs"`
+	Updated   time.Time `json:"updated"`
+	// Pending is set while a sync stages the image and hasn't pushed it yet
+	Pending bool `json:"pending,omitempty"`
+}
+
+// DefaultPath returns ~/.registry-mirror/blobs
+func DefaultPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".registry-mirror", "blobs"), nil
+}
+
+// Open opens (creating if needed) a blob store rooted at dir
+func Open(dir string) (*Store, error) {
+	for _, sub := range []string{"sha256", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create blob store: %w", err)
+		}
+	}
+
+	s := &Store{root: dir, images: make(map[string]ImageRefs)}
+	if err := s.loadLocked(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Path returns where the blob with the given digest lives on disk
+func (s *Store) Path(digest string) string {
+	return filepath.Join(s.root, "sha256", strings.TrimPrefix(digest, "sha256:"))
+}
+
+// Has reports whether the blob is in the store
+func (s *Store) Has(digest string) bool {
+	_, err := os.Stat(s.Path(digest))
+	return err == nil
+}
+
+// Put writes a blob to the store. The content is hashed while it's written to a
+// temp file and only renamed into place if it matches digest, so readers never
+// see partial or corrupt blobs.
+func (s *Store) Put(digest string, r io.Reader) (int64, error) {
+	if !strings.HasPrefix(digest, "sha256:") {
+		return 0, fmt.Errorf("unsupported digest algorithm: %s", digest)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(s.root, "tmp"), "blob-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+
+	if got := fmt.Sprintf("sha256:%x", h.Sum(nil)); got != digest {
+		return 0, fmt.Errorf("%w: expected %s, got %s", ErrDigestMismatch, digest, got)
+	}
+
+	if err := os.Rename(tmp.Name(), s.Path(digest)); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// PutBytes stores small content such as manifests
+func (s *Store) PutBytes(digest string, data []byte) error {
+	_, err := s.Put(digest, bytes.NewReader(data))
+	return err
+}
+
+// Get opens a blob for reading and returns its size
+func (s *Store) Get(digest string) (io.ReadCloser, int64, error) {
+	f, err := os.Open(s.Path(digest))
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+// ReadBytes reads a whole blob into memory
+func (s *Store) ReadBytes(digest string) ([]byte, error) {
+	return os.ReadFile(s.Path(digest))
+}
+
+// Verify re-hashes a stored blob and removes it if it no longer matches its digest
+func (s *Store) Verify(digest string) error {
+	f, err := os.Open(s.Path(digest))
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	if got := fmt.Sprintf("sha256:%x", h.Sum(nil)); got != digest {
+		os.Remove(s.Path(digest))
+		return fmt.Errorf("blob %s is corrupt (hashes to %s), removed", digest, got)
+	}
+	return nil
+}
+
+// SetImage records which manifest and blobs an image references, replacing
+// any previous references for that image
+func (s *Store) SetImage(image string, refs ImageRefs) error {
+	seen := make(map[string]bool)
+	var blobs []string
+	for _, d := range refs.Blobs {
+		if !seen[d] {
+			seen[d] = true
+			blobs = append(blobs, d)
+		}
+	}
+	refs.Blobs = blobs
+	refs.Updated = time.Now()
+
+	return s.update(func() {
+		s.images[imageKey(image)] = refs
+	})
+}
+
+// Stage records the references of an image a sync is about to download, so
+// GC keeps its blobs while they're transferred and after a failed push
+func (s *Store) Stage(image string, refs ImageRefs) error {
+	refs.Pending = true
+	return s.SetImage(image, refs)
+}
+
+// Unstage drops the references Stage recorded, unless the image has been
+// pushed since
+func (s *Store) Unstage(image string) error {
+	return s.update(func() {
+		if refs, ok := s.images[imageKey(image)]; ok && refs.Pending {
+			delete(s.images, imageKey(image))
+		}
+	})
+}
+
+// Image returns the references recorded for an image
+func (s *Store) Image(image string) (ImageRefs, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.refreshLocked()
+	refs, ok := s.images[imageKey(image)]
+	return refs, ok
+}
+
+// Release drops an image's references. Its blobs stay on disk until GC runs.
+func (s *Store) Release(image string) error {
+	return s.update(func() {
+		delete(s.images, imageKey(image))
+	})
+}
+
+// RefCount returns how many images reference a blob
+func (s *Store) RefCount(digest string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.refreshLocked()
+	return s.refCountsLocked()[digest]
+}
+
+func (s *Store) refCountsLocked() map[string]int {
+	counts := make(map[string]int)
+	for _, refs := range s.images {
+		if refs.Manifest != "" {
+			counts[refs.Manifest]++
+		}
+		for _, d := range refs.Blobs {
+			counts[d]++
+		}
+	}
+	return counts
+}
+
+// UniqueBytes returns the bytes only this image references, i.e. what
+// releasing it and running GC would free
+func (s *Store) UniqueBytes(image string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.refreshLocked()
+	refs, ok := s.images[imageKey(image)]
+	if !ok {
+		return 0
+	}
+
+	counts := s.refCountsLocked()
+	var total int64
+	for _, d := range append([]string{refs.Manifest}, refs.Blobs...) {
+		if counts[d] == 1 {
+			if info, err := os.Stat(s.Path(d)); err == nil {
+				total += info.Size()
+			}
+		}
+	}
+	return total
+}
+
+// Images returns the names of all images with references, sorted
+func (s *Store) Images() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.refreshLocked()
+	names := make([]string, 0, len(s.images))
+	for name := range s.images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Usage returns the number of blobs and total bytes stored on disk
+func (s *Store) Usage() (int, int64, error) {
+	entries, err := os.ReadDir(filepath.Join(s.root, "sha256"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var total int64
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		total += info.Size()
+	}
+	return len(entries), total, nil
+}
+
+// GC removes blobs no image references anymore and returns what it freed.
+// Blobs written in the last hour are kept, as a sync may not have recorded
+// its references yet.
+func (s *Store) GC() (int, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Hold the lock so no other process adds references while we delete
+	unlock, err := s.lockRefs()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer unlock()
+	if err := s.loadLocked(); err != nil {
+		return 0, 0, err
+	}
+
+	counts := s.refCountsLocked()
+
+	entries, err := os.ReadDir(filepath.Join(s.root, "sha256"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var removed int
+	var freed int64
+	cutoff := time.Now().Add(-gcGrace)
+	for _, e := range entries {
+		digest := "sha256:" + e.Name()
+		if counts[digest] > 0 {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(s.Path(digest)); err != nil {
+			return removed, freed, err
+		}
+		removed++
+		freed += info.Size()
+	}
+	return removed, freed, nil
+}
+
+func (s *Store) refsPath() string {
+	return filepath.Join(s.root, "refs.json")
+}
+
+// lockRefs takes the lock processes sharing the store hold to change refs.json
+func (s *Store) lockRefs() (func() error, error) {
+	unlock, err := filelock.Lock(filepath.Join(s.root, "refs.lock"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock blob store refs: %w", err)
+	}
+	return unlock, nil
+}
+
+// update changes the references and saves them, re-reading refs.json first
+// under the lock so changes other processes made aren't overwritten
+func (s *Store) update(change func()) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := s.lockRefs()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.loadLocked(); err != nil {
+		return err
+	}
+	change()
+	return s.saveLocked()
+}
+
+// refreshLocked reloads the references if another process changed them.
+// Readers keep what they have if that fails.
+func (s *Store) refreshLocked() {
+	s.loadLocked()
+}
+
+// loadLocked reads refs.json unless it's the one already loaded
+func (s *Store) loadLocked() error {
+	info, err := os.Stat(s.refsPath())
+	if os.IsNotExist(err) {
+		s.images, s.loaded = make(map[string]ImageRefs), nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// refs.json is replaced by rename, so a new file means new content
+	if s.loaded != nil && os.SameFile(info, s.loaded) && info.ModTime().Equal(s.loaded.ModTime()) {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.refsPath())
+	if err != nil {
+		return err
+	}
+	images := make(map[string]ImageRefs)
+	if len(data) > 0 {
+		var stored map[string]ImageRefs
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return fmt.Errorf("corrupt blob store refs: %w", err)
+		}
+		// Stores written before keys were normalized may hold an image twice
+		for name, refs := range stored {
+			key := imageKey(name)
+			if prev, ok := images[key]; !ok || refs.Updated.After(prev.Updated) {
+				images[key] = refs
+			}
+		}
+	}
+	s.images, s.loaded = images, info
+	return nil
+}
+
+// saveLocked writes the refs index atomically (temp file + rename)
+func (s *Store) saveLocked() error {
+	data, err := json.MarshalIndent(s.images, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(s.root, "tmp"), "refs-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.refsPath()); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(s.refsPath())
+	if err != nil {
+		return err
+	}
+	s.loaded = info
+	return nil
+}
+
+// imageKey names an image the way Docker Hub resolves it, so nginx:latest
+// and library/nginx:latest (as pulled through serve) are the same image
+func imageKey(image string) string {
+	name, ref := registry.ParseReference(image)
+	name = registry.UpstreamName(strings.TrimPrefix(name, "docker.io/"))
+	if strings.Contains(image, "@") {
+		return name + "@" + ref
+	}
+	return name + ":" + ref
+}
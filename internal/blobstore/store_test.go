@@ -0,0 +1,166 @@
+package blobstore
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func digestOf(s string) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(s)))
+}
+
+// age makes blobs look older than the GC grace period
+func age(t *testing.T, store *Store, digests ...string) {
+	old := time.Now().Add(-2 * gcGrace)
+	for _, d := range digests {
+		if err := os.Chtimes(store.Path(d), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPutVerifiesDigest(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := digestOf("hello")
+	if _, err := store.Put(d, strings.NewReader("hello")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if !store.Has(d) {
+		t.Error("Expected blob to be stored")
+	}
+
+	bad := digestOf("other")
+	if _, err := store.Put(bad, strings.NewReader("hello")); err == nil {
+		t.Error("Expected digest mismatch error")
+	}
+	if store.Has(bad) {
+		t.Error("Mismatched blob must not be stored")
+	}
+}
+
+func TestVerifyRemovesCorruptBlob(t *testing.T) {
+	store, _ := Open(t.TempDir())
+
+	d := digestOf("hello")
+	store.PutBytes(d, []byte("hello"))
+	os.WriteFile(store.Path(d), []byte("tampered"), 0644)
+
+	if err := store.Verify(d); err == nil {
+		t.Error("Expected corrupt blob to fail verification")
+	}
+	if store.Has(d) {
+		t.Error("Corrupt blob should have been removed")
+	}
+}
+
+func TestRefCountingAndGC(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := Open(dir)
+
+	shared, onlyA := digestOf("shared"), digestOf("only-a")
+	store.PutBytes(shared, []byte("shared"))
+	store.PutBytes(onlyA, []byte("only-a"))
+	age(t, store, shared, onlyA)
+
+	store.SetImage("a:1", ImageRefs{Blobs: []string{shared, onlyA}})
+	store.SetImage("b:1", ImageRefs{Blobs: []string{shared}})
+
+	if n := store.RefCount(shared); n != 2 {
+		t.Errorf("Expected shared refcount 2, got %d", n)
+	}
+	if n := store.UniqueBytes("a:1"); n != int64(len("only-a")) {
+		t.Errorf("Expected a:1 unique bytes %d, got %d", len("only-a"), n)
+	}
+
+	// References survive reopening
+	store, _ = Open(dir)
+	store.Release("a:1")
+
+	removed, freed, err := store.GC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 || freed != int64(len("only-a")) {
+		t.Errorf("Expected GC to remove only-a, removed=%d freed=%d", removed, freed)
+	}
+	if !store.Has(shared) {
+		t.Error("Shared blob still referenced by b:1 was removed")
+	}
+}
+
+func TestStoresSharingADirectoryKeepEachOthersRefs(t *testing.T) {
+	dir := t.TempDir()
+	// Like serve and sync running side by side
+	serve, _ := Open(dir)
+	sync, _ := Open(dir)
+
+	a, b := digestOf("a"), digestOf("b")
+	serve.PutBytes(a, []byte("a"))
+	sync.PutBytes(b, []byte("b"))
+	age(t, serve, a, b)
+
+	if err := serve.SetImage("library/nginx:latest", ImageRefs{Blobs: []string{a}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sync.SetImage("redis:7", ImageRefs{Blobs: []string{b}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if removed, _, err := serve.GC(); err != nil || removed != 0 {
+		t.Errorf("Expected GC to keep blobs referenced by either store, removed %d (%v)", removed, err)
+	}
+	reopened, _ := Open(dir)
+	if got := reopened.Images(); len(got) != 2 {
+		t.Errorf("Expected both images in refs.json, got %v", got)
+	}
+
+	// The syncer's short names and the server's full ones are one image
+	if _, ok := sync.Image("nginx:latest"); !ok {
+		t.Error("Expected nginx:latest to find library/nginx:latest")
+	}
+	sync.Release("docker.io/library/nginx")
+	if _, ok := serve.Image("library/nginx:latest"); ok {
+		t.Error("Expected the release to reach the other store")
+	}
+}
+
+func TestGCKeepsStagedAndFreshBlobs(t *testing.T) {
+	store, _ := Open(t.TempDir())
+
+	staged, fresh := digestOf("staged"), digestOf("fresh")
+	store.PutBytes(staged, []byte("staged"))
+	store.PutBytes(fresh, []byte("fresh"))
+	age(t, store, staged)
+
+	// A sync referenced its blobs before downloading them, and its push failed
+	if err := store.Stage("redis:7", ImageRefs{Blobs: []string{staged}}); err != nil {
+		t.Fatal(err)
+	}
+	if refs, ok := store.Image("redis:7"); !ok || !refs.Pending {
+		t.Fatalf("Expected pending references, got %+v", refs)
+	}
+	if removed, _, err := store.GC(); err != nil || removed != 0 {
+		t.Errorf("Expected GC to keep staged and recently written blobs, removed %d (%v)", removed, err)
+	}
+
+	// Once pushed, the references are no longer pending and can't be unstaged
+	store.SetImage("redis:7", ImageRefs{Blobs: []string{staged}})
+	store.Unstage("redis:7")
+	if refs, ok := store.Image("redis:7"); !ok || refs.Pending {
+		t.Errorf("Expected the pushed image to keep its references, got %+v", refs)
+	}
+
+	store.Stage("postgres:16", ImageRefs{Blobs: []string{fresh}})
+	store.Unstage("postgres:16")
+	if _, ok := store.Image("postgres:16"); ok {
+		t.Error("Expected unstaging to drop the pending references")
+	}
+}
@@ -0,0 +1,311 @@
+package bundle
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/registry"
+)
+
+const indexFile = "index.json"
+
+// Index is stored as index.json at the start of every bundle
+type Index struct {
+	Version   int       `json:"version"`
+	Created   time.Time `json:"created"`
+	Images    []Image   `json:"images"`
+	Manifests []Entry   `json:"manifests"` // children before the indexes that reference them
+	Blobs     []Entry   `json:"blobs"`
+	Mounts    []Mount   `json:"mounts"`
+	Skipped   []string  `json:"skipped,omitempty"` // images the target already has
+}
+
+type Image struct {
+	Repository string   `json:"repository"`
+	Tag        string   `json:"tag"`
+	Digest     string   `json:"digest"`
+	MediaType  string   `json:"mediaType"`
+	Blobs      []string `json:"blobs"` // every blob the image references, shipped or not
+}
+
+type Entry struct {
+	Repository string `json:"repository"`
+	Digest     string `json:"digest"`
+	MediaType  string `json:"mediaType"`
+	Size       int64  `json:"size"`
+}
+
+// Mount is a blob that already exists on the target (or earlier in the
+// bundle) under another repository and only needs to be linked
+type Mount struct {
+	Repository string `json:"repository"`
+	Digest     string `json:"digest"`
+	From       string `json:"from"`
+}
+
+// Exporter writes delta bundles from the local registry
+type Exporter struct {
+	client *registry.Client
+	have   *Inventory
+}
+
+// NewExporter creates an exporter. have may be nil to export everything.
+func NewExporter(client *registry.Client, have *Inventory) *Exporter {
+	return &Exporter{client: client, have: have}
+}
+
+// Export writes a bundle for the given images (all local images if empty) to w
+func (e *Exporter) Export(ctx context.Context, images []string, w io.Writer) (*Index, error) {
+	if len(images) == 0 {
+		all, err := e.allImages(ctx)
+		if err != nil {
+			return nil, err
+		}
+		images = all
+	}
+
+	p := newPlanner(e.have)
+	for _, image := range images {
+		name, tag := registry.ParseReference(image)
+		tree, err := e.fetchTree(ctx, name, tag)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.addImage(name, tag, tree); err != nil {
+			return nil, err
+		}
+	}
+
+	tw := tar.NewWriter(w)
+
+	indexData, err := json.MarshalIndent(p.index, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFile(tw, indexFile, indexData); err != nil {
+		return nil, err
+	}
+
+	written := make(map[string]bool)
+	for _, m := range p.index.Manifests {
+		if written[m.Digest] {
+			continue
+		}
+		written[m.Digest] = true
+		if err := writeFile(tw, manifestPath(m.Digest), p.manifests[m.Digest].Data); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, b := range p.index.Blobs {
+		if err := e.writeBlob(ctx, tw, b); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return p.index, nil
+}
+
+func (e *Exporter) allImages(ctx context.Context) ([]string, error) {
+	repos, err := e.client.ListRepositories(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []string
+	for _, name := range repos {
+		tags, err := e.client.ListTags(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range tags {
+			images = append(images, name+":"+tag)
+		}
+	}
+	return images, nil
+}
+
+// fetchTree returns the manifest for name:tag followed by any child manifests
+func (e *Exporter) fetchTree(ctx context.Context, name, tag string) ([]*registry.RawManifest, error) {
+	root, err := e.client.GetLocalManifest(ctx, name, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := []*registry.RawManifest{root}
+	m, err := root.Parse()
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range m.Manifests {
+		raw, err := e.client.GetLocalManifest(ctx, name, child.Digest)
+		if err != nil {
+			return nil, err
+		}
+		tree = append(tree, raw)
+	}
+	return tree, nil
+}
+
+func (e *Exporter) writeBlob(ctx context.Context, tw *tar.Writer, b Entry) error {
+	data, err := e.client.PullLocalBlob(ctx, b.Repository, b.Digest)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    blobPath(b.Digest),
+		Mode:    0644,
+		Size:    b.Size,
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+
+	if _, err := io.CopyN(tw, data, b.Size); err != nil {
+		return fmt.Errorf("failed to copy blob %s: %w", b.Digest, err)
+	}
+	return nil
+}
+
+// planner decides what goes into a bundle given the target's inventory
+type planner struct {
+	index     *Index
+	manifests map[string]*registry.RawManifest
+	owners    map[string]string // blob -> repository that has it on the target
+	targetHas map[string]bool   // "repo@digest" pairs present on the target
+	have      *Inventory
+}
+
+func newPlanner(have *Inventory) *planner {
+	p := &planner{
+		index:     &Index{Version: 1, Created: time.Now().UTC()},
+		manifests: make(map[string]*registry.RawManifest),
+		owners:    have.blobOwners(),
+		targetHas: make(map[string]bool),
+		have:      have,
+	}
+	if have != nil {
+		for _, repo := range have.Repositories {
+			for _, d := range repo.Blobs {
+				p.targetHas[repo.Name+"@"+d] = true
+			}
+			for _, d := range repo.Manifests {
+				p.targetHas[repo.Name+"@"+d] = true
+			}
+		}
+	}
+	return p
+}
+
+// addImage plans one image. tree[0] is the tagged manifest, the rest are its children.
+func (p *planner) addImage(name, tag string, tree []*registry.RawManifest) error {
+	root := tree[0]
+	if p.have.tagDigest(name, tag) == root.Digest {
+		p.index.Skipped = append(p.index.Skipped, name+":"+tag)
+		return nil
+	}
+
+	img := Image{Repository: name, Tag: tag, Digest: root.Digest, MediaType: root.MediaType}
+
+	// Children first so the registry accepts the index that references them
+	for i := len(tree) - 1; i >= 0; i-- {
+		raw := tree[i]
+		m, err := raw.Parse()
+		if err != nil {
+			return err
+		}
+
+		var blobs []registry.Layer
+		if m.Config.Digest != "" {
+			blobs = append(blobs, m.Config)
+		}
+		blobs = append(blobs, m.Layers...)
+
+		for _, b := range blobs {
+			img.Blobs = append(img.Blobs, b.Digest)
+			p.addBlob(name, b)
+		}
+
+		// The tagged manifest always ships: even if the target has the digest,
+		// the tag has to be (re)pointed at it
+		if i == 0 || !p.targetHas[name+"@"+raw.Digest] {
+			p.addManifest(name, raw)
+		}
+	}
+
+	p.index.Images = append(p.index.Images, img)
+	return nil
+}
+
+func (p *planner) addBlob(name string, b registry.Layer) {
+	key := name + "@" + b.Digest
+	if p.targetHas[key] {
+		return
+	}
+	p.targetHas[key] = true
+
+	if from, ok := p.owners[b.Digest]; ok {
+		p.index.Mounts = append(p.index.Mounts, Mount{Repository: name, Digest: b.Digest, From: from})
+		return
+	}
+
+	p.owners[b.Digest] = name
+	p.index.Blobs = append(p.index.Blobs, Entry{
+		Repository: name,
+		Digest:     b.Digest,
+		MediaType:  b.MediaType,
+		Size:       b.Size,
+	})
+}
+
+func (p *planner) addManifest(name string, raw *registry.RawManifest) {
+	if _, ok := p.manifests[raw.Digest]; !ok {
+		p.manifests[raw.Digest] = raw
+	}
+	p.index.Manifests = append(p.index.Manifests, Entry{
+		Repository: name,
+		Digest:     raw.Digest,
+		MediaType:  raw.MediaType,
+		Size:       int64(len(raw.Data)),
+	})
+}
+
+// TotalBytes is the size of all blobs shipped in the bundle
+func (idx *Index) TotalBytes() int64 {
+	var total int64
+	for _, b := range idx.Blobs {
+		total += b.Size
+	}
+	return total
+}
+
+func writeFile(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+func manifestPath(digest string) string {
+	return "manifests/" + strings.Replace(digest, ":", "/", 1)
+}
+
+func blobPath(digest string) string {
+	return "blobs/" + strings.Replace(digest, ":", "/", 1)
+}
@@ -0,0 +1,74 @@
+package bundle
+
+import (
+	"testing"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/registry"
+)
+
+func testManifest(digest string, layers ...string) *registry.RawManifest {
+	data := `{"schemaVersion":2,"config":{"digest":"sha256:cfg","size":10},"layers":[`
+	for i, l := range layers {
+		if i > 0 {
+			data += ","
+		}
+		data += `{"digest":"` + l + `","size":100}`
+	}
+	data += `]}`
+	return &registry.RawManifest{Digest: digest, MediaType: registry.MediaTypeDockerManifest, Data: []byte(data)}
+}
+
+func TestPlannerWithoutInventory(t *testing.T) {
+	p := newPlanner(nil)
+	if err := p.addImage("library/nginx", "latest", []*registry.RawManifest{testManifest("sha256:m1", "sha256:a", "sha256:b")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(p.index.Blobs) != 3 {
+		t.Errorf("Expected 3 blobs (config + 2 layers), got %d", len(p.index.Blobs))
+	}
+	if len(p.index.Manifests) != 1 {
+		t.Errorf("Expected 1 manifest, got %d", len(p.index.Manifests))
+	}
+}
+
+func TestPlannerDelta(t *testing.T) {
+	have := &Inventory{Repositories: []RepositoryInventory{{
+		Name:      "library/nginx",
+		Tags:      map[string]string{"latest": "sha256:old"},
+		Manifests: []string{"sha256:old"},
+		Blobs:     []string{"sha256:cfg", "sha256:a"},
+	}}}
+
+	p := newPlanner(have)
+	p.addImage("library/nginx", "latest", []*registry.RawManifest{testManifest("sha256:new", "sha256:a", "sha256:b")})
+	p.addImage("library/redis", "7", []*registry.RawManifest{testManifest("sha256:r1", "sha256:a", "sha256:b")})
+
+	if len(p.index.Blobs) != 1 || p.index.Blobs[0].Digest != "sha256:b" {
+		t.Fatalf("Expected only sha256:b to be shipped, got %+v", p.index.Blobs)
+	}
+
+	// redis needs cfg and a from the target's nginx repo, and b from the bundle's nginx push
+	if len(p.index.Mounts) != 3 {
+		t.Errorf("Expected 3 mounts for redis, got %+v", p.index.Mounts)
+	}
+	for _, m := range p.index.Mounts {
+		if m.Repository != "library/redis" || m.From != "library/nginx" {
+			t.Errorf("Unexpected mount %+v", m)
+		}
+	}
+}
+
+func TestPlannerSkipsUpToDateTags(t *testing.T) {
+	have := &Inventory{Repositories: []RepositoryInventory{{
+		Name: "library/nginx",
+		Tags: map[string]string{"latest": "sha256:m1"},
+	}}}
+
+	p := newPlanner(have)
+	p.addImage("library/nginx", "latest", []*registry.RawManifest{testManifest("sha256:m1", "sha256:a")})
+
+	if len(p.index.Images) != 0 || len(p.index.Skipped) != 1 {
+		t.Errorf("Expected image to be skipped, got images=%d skipped=%d", len(p.index.Images), len(p.index.Skipped))
+	}
+}
@@ -0,0 +1,196 @@
+package bundle
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/registry"
+)
+
+// Importer applies bundles to the local registry
+type Importer struct {
+	client *registry.Client
+}
+
+func NewImporter(client *registry.Client) *Importer {
+	return &Importer{client: client}
+}
+
+// ImportResult summarises what an import changed
+type ImportResult struct {
+	Index     *Index
+	Blobs     int
+	Mounts    int
+	Manifests int
+}
+
+// MissingBlobsError is returned when the target registry is still missing
+// blobs after a bundle has been applied, e.g. because it was exported against
+// an outdated inventory
+type MissingBlobsError struct {
+	Missing []string // "repository@digest"
+}
+
+func (e *MissingBlobsError) Error() string {
+	return fmt.Sprintf("%d referenced blobs are missing after import (first: %s)", len(e.Missing), e.Missing[0])
+}
+
+// Import unpacks the bundle read from r, pushes its contents and then checks
+// that every blob referenced by the bundled images exists in the registry
+func (im *Importer) Import(ctx context.Context, r io.Reader) (*ImportResult, error) {
+	dir, err := os.MkdirTemp("", "registry-mirror-bundle-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := extract(r, dir); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, indexFile))
+	if err != nil {
+		return nil, fmt.Errorf("not a registry-mirror bundle: %w", err)
+	}
+	var idx Index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("invalid bundle index: %w", err)
+	}
+
+	result := &ImportResult{Index: &idx}
+
+	for _, b := range idx.Blobs {
+		if err := im.pushBlob(ctx, dir, b); err != nil {
+			return result, err
+		}
+		result.Blobs++
+	}
+
+	for _, m := range idx.Mounts {
+		if err := im.client.MountBlob(ctx, m.Repository, m.Digest, m.From); err != nil {
+			return result, err
+		}
+		result.Mounts++
+	}
+
+	for _, m := range idx.Manifests {
+		raw, err := readManifest(dir, m)
+		if err != nil {
+			return result, err
+		}
+		if _, err := im.client.PushManifest(ctx, m.Repository, m.Digest, raw); err != nil {
+			return result, err
+		}
+		result.Manifests++
+	}
+
+	for _, img := range idx.Images {
+		raw, err := readManifest(dir, Entry{Repository: img.Repository, Digest: img.Digest, MediaType: img.MediaType})
+		if err != nil {
+			return result, err
+		}
+		if _, err := im.client.PushManifest(ctx, img.Repository, img.Tag, raw); err != nil {
+			return result, err
+		}
+	}
+
+	return result, im.Validate(ctx, &idx)
+}
+
+// Validate checks that every blob referenced by the bundled images exists
+func (im *Importer) Validate(ctx context.Context, idx *Index) error {
+	var missing []string
+	seen := make(map[string]bool)
+
+	for _, img := range idx.Images {
+		for _, d := range img.Blobs {
+			key := img.Repository + "@" + d
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			ok, err := im.client.BlobExists(ctx, img.Repository, d)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				missing = append(missing, key)
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		return &MissingBlobsError{Missing: missing}
+	}
+	return nil
+}
+
+func (im *Importer) pushBlob(ctx context.Context, dir string, b Entry) error {
+	f, err := os.Open(filepath.Join(dir, blobPath(b.Digest)))
+	if err != nil {
+		return fmt.Errorf("bundle is missing blob %s: %w", b.Digest, err)
+	}
+	defer f.Close()
+
+	return im.client.PushLayer(ctx, b.Repository, b.Digest, f)
+}
+
+func readManifest(dir string, m Entry) (*registry.RawManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestPath(m.Digest)))
+	if err != nil {
+		return nil, fmt.Errorf("bundle is missing manifest %s: %w", m.Digest, err)
+	}
+
+	return &registry.RawManifest{Digest: m.Digest, MediaType: m.MediaType, Data: data}, nil
+}
+
+// extract unpacks the tar stream into dir, verifying content digests as it goes
+func extract(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read bundle: %w", err)
+		}
+
+		name := filepath.Clean(hdr.Name)
+		if strings.HasPrefix(name, "..") || filepath.IsAbs(name) {
+			return fmt.Errorf("bundle entry %q escapes the bundle", hdr.Name)
+		}
+
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		_, err = io.Copy(io.MultiWriter(f, h), tr)
+		f.Close()
+		if err != nil {
+			return err
+		}
+
+		// blobs/sha256/<hex> and manifests/sha256/<hex> must hash to their name
+		parts := strings.Split(filepath.ToSlash(name), "/")
+		if len(parts) == 3 && parts[1] == "sha256" {
+			if got := fmt.Sprintf("%x", h.Sum(nil)); got != parts[2] {
+				return fmt.Errorf("digest mismatch for %s: got sha256:%s", name, got)
+			}
+		}
+	}
+}
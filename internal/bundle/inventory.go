@@ -0,0 +1,147 @@
+package bundle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/registry"
+)
+
+// Inventory describes everything a registry already holds. It is produced on
+// the target side of an air gap and fed to Export so only the delta is shipped.
+type Inventory struct {
+	Registry     string                `json:"registry"`
+	Generated    time.Time             `json:"generated"`
+	Repositories []RepositoryInventory `json:"repositories"`
+}
+
+type RepositoryInventory struct {
+	Name      string            `json:"name"`
+	Tags      map[string]string `json:"tags"` // tag -> manifest digest
+	Manifests []string          `json:"manifests"`
+	Blobs     []string          `json:"blobs"`
+}
+
+// BuildInventory walks every repository and tag of the local registry
+func BuildInventory(ctx context.Context, client *registry.Client, registryAddr string) (*Inventory, error) {
+	repos, err := client.ListRepositories(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	inv := &Inventory{Registry: registryAddr, Generated: time.Now().UTC()}
+	for _, name := range repos {
+		tags, err := client.ListTags(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+
+		repo := RepositoryInventory{Name: name, Tags: make(map[string]string)}
+		manifests := make(map[string]bool)
+		blobs := make(map[string]bool)
+
+		for _, tag := range tags {
+			raw, err := client.GetLocalManifest(ctx, name, tag)
+			if err != nil {
+				return nil, err
+			}
+			repo.Tags[tag] = raw.Digest
+			if err := collect(ctx, client, name, raw, manifests, blobs); err != nil {
+				return nil, err
+			}
+		}
+
+		repo.Manifests = sortedKeys(manifests)
+		repo.Blobs = sortedKeys(blobs)
+		inv.Repositories = append(inv.Repositories, repo)
+	}
+
+	return inv, nil
+}
+
+// collect records a manifest and everything it references, following index entries
+func collect(ctx context.Context, client *registry.Client, name string, raw *registry.RawManifest, manifests, blobs map[string]bool) error {
+	if manifests[raw.Digest] {
+		return nil
+	}
+	manifests[raw.Digest] = true
+
+	m, err := raw.Parse()
+	if err != nil {
+		return err
+	}
+
+	for _, child := range m.Manifests {
+		childRaw, err := client.GetLocalManifest(ctx, name, child.Digest)
+		if err != nil {
+			return err
+		}
+		if err := collect(ctx, client, name, childRaw, manifests, blobs); err != nil {
+			return err
+		}
+	}
+
+	if m.Config.Digest != "" {
+		blobs[m.Config.Digest] = true
+	}
+	for _, l := range m.Layers {
+		blobs[l.Digest] = true
+	}
+	return nil
+}
+
+// LoadInventory reads an inventory previously written with `registry-mirror inventory`
+func LoadInventory(path string) (*Inventory, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var inv Inventory
+	if err := json.Unmarshal(data, &inv); err != nil {
+		return nil, fmt.Errorf("invalid inventory %s: %w", path, err)
+	}
+	return &inv, nil
+}
+
+// blobOwners maps every blob digest to a repository that holds it
+func (inv *Inventory) blobOwners() map[string]string {
+	owners := make(map[string]string)
+	if inv == nil {
+		return owners
+	}
+	for _, repo := range inv.Repositories {
+		for _, d := range repo.Blobs {
+			if _, ok := owners[d]; !ok {
+				owners[d] = repo.Name
+			}
+		}
+	}
+	return owners
+}
+
+// tagDigest returns the digest a tag points to on the target, if any
+func (inv *Inventory) tagDigest(name, tag string) string {
+	if inv == nil {
+		return ""
+	}
+	for _, repo := range inv.Repositories {
+		if repo.Name == name {
+			return repo.Tags[tag]
+		}
+	}
+	return ""
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
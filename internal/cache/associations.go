@@ -0,0 +1,222 @@
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/storage"
+)
+
+const (
+	// DefaultSessionGap splits a client's pulls into sessions: a pull more
+	// than this long after the previous one starts a new session
+	DefaultSessionGap = 30 * time.Minute
+
+	// rulesRefresh is how long mined rules are reused before re-reading history
+	rulesRefresh = 10 * time.Minute
+)
+
+// Rule says that sessions pulling From also pulled To
+type Rule struct {
+	From       string  `json:"from"`
+	To         string  `json:"to"`
+	Support    int     `json:"support"`    // sessions that pulled both
+	Confidence float64 `json:"confidence"` // Support / sessions that pulled From
+}
+
+func (r Rule) String() string {
+	return fmt.Sprintf("%s → %s (%.0f%%, %d sessions)", r.From, r.To, r.Confidence*100, r.Support)
+}
+
+// Associations mines pull history for images that are pulled together
+type Associations struct {
+	db            storage.DB
+	sessionGap    time.Duration
+	minSupport    int
+	minConfidence float64
+
+	mu    sync.Mutex
+	rules []Rule
+	built time.Time
+}
+
+// NewAssociations creates a miner. Rules need to be seen in at least
+// minSupport sessions with at least minConfidence (0..1) to be reported.
+func NewAssociations(db storage.DB, sessionGap time.Duration, minSupport int, minConfidence float64) *Associations {
+	return &Associations{
+		db:            db,
+		sessionGap:    sessionGap,
+		minSupport:    minSupport,
+		minConfidence: minConfidence,
+	}
+}
+
+// Rules returns the association rules learned from the last 30 days of pulls,
+// strongest first
+func (a *Associations) Rules() ([]Rule, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.rules != nil && time.Since(a.built) < rulesRefresh {
+		return a.rules, nil
+	}
+
+	pulls, err := a.db.GetPulls(time.Now().Add(-pullWindow))
+	if err != nil {
+		return nil, err
+	}
+
+	a.rules = MineRules(Sessions(pulls, a.sessionGap), a.minSupport, a.minConfidence)
+	a.built = time.Now()
+	return a.rules, nil
+}
+
+// Companions returns the rules whose From is image
+func (a *Associations) Companions(image string) ([]Rule, error) {
+	rules, err := a.Rules()
+	if err != nil {
+		return nil, err
+	}
+
+	key := imageKey(image)
+	var out []Rule
+	for _, r := range rules {
+		if r.From == key {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+// Sessions groups pulls per client into sets of images. pulls must be
+// ordered by time, as returned by storage.GetPulls.
+func Sessions(pulls []storage.PullRecord, gap time.Duration) []map[string]bool {
+	var sessions []map[string]bool
+	current := make(map[string]map[string]bool)
+	last := make(map[string]time.Time)
+
+	for _, p := range pulls {
+		client := p.ClientIP
+		if s, ok := current[client]; ok && p.Timestamp.Sub(last[client]) > gap {
+			sessions = append(sessions, s)
+			delete(current, client)
+		}
+		if _, ok := current[client]; !ok {
+			current[client] = make(map[string]bool)
+		}
+		current[client][imageKey(p.Image+":"+p.Tag)] = true
+		last[client] = p.Timestamp
+	}
+
+	// Flush open sessions in a stable order
+	clients := make([]string, 0, len(current))
+	for c := range current {
+		clients = append(clients, c)
+	}
+	sort.Strings(clients)
+	for _, c := range clients {
+		sessions = append(sessions, current[c])
+	}
+	return sessions
+}
+
+// MineRules computes pairwise association rules between images in the same session
+func MineRules(sessions []map[string]bool, minSupport int, minConfidence float64) []Rule {
+	single := make(map[string]int)
+	pair := make(map[[2]string]int)
+
+	for _, s := range sessions {
+		for a := range s {
+			single[a]++
+			for b := range s {
+				if a != b {
+					pair[[2]string{a, b}]++
+				}
+			}
+		}
+	}
+
+	var rules []Rule
+	for p, support := range pair {
+		if support < minSupport {
+			continue
+		}
+		confidence := float64(support) / float64(single[p[0]])
+		if confidence < minConfidence {
+			continue
+		}
+		rules = append(rules, Rule{From: p[0], To: p[1], Support: support, Confidence: confidence})
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Confidence != rules[j].Confidence {
+			return rules[i].Confidence > rules[j].Confidence
+		}
+		if rules[i].Support != rules[j].Support {
+			return rules[i].Support > rules[j].Support
+		}
+		if rules[i].From != rules[j].From {
+			return rules[i].From < rules[j].From
+		}
+		return rules[i].To < rules[j].To
+	})
+	return rules
+}
+
+// Prefetcher fetches the companions of every pulled image in the background
+type Prefetcher struct {
+	assoc    *Associations
+	fetch    func(image string) error
+	cooldown time.Duration
+
+	mu     sync.Mutex
+	recent map[string]time.Time
+}
+
+// NewPrefetcher creates a prefetcher that calls fetch for companion images,
+// at most once per image per hour
+func NewPrefetcher(assoc *Associations, fetch func(image string) error) *Prefetcher {
+	return &Prefetcher{
+		assoc:    assoc,
+		fetch:    fetch,
+		cooldown: time.Hour,
+		recent:   make(map[string]time.Time),
+	}
+}
+
+// Pulled is called whenever an image is pulled. It returns immediately.
+func (p *Prefetcher) Pulled(image string) {
+	go p.prefetch(image)
+}
+
+func (p *Prefetcher) prefetch(image string) {
+	rules, err := p.assoc.Companions(image)
+	if err != nil {
+		fmt.Printf("⚠️  Failed to load association rules: %v\n", err)
+		return
+	}
+
+	for _, r := range rules {
+		if !p.claim(r.To) {
+			continue
+		}
+		fmt.Printf("🔗 Prefetching %s\n", r)
+		if err := p.fetch(r.To); err != nil {
+			fmt.Printf("⚠️  Prefetch of %s failed: %v\n", r.To, err)
+		}
+	}
+}
+
+// claim returns false if the image was prefetched within the cooldown
+func (p *Prefetcher) claim(image string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if t, ok := p.recent[image]; ok && time.Since(t) < p.cooldown {
+		return false
+	}
+	p.recent[image] = time.Now()
+	return true
+}
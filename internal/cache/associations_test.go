@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/storage"
+)
+
+func TestSessionsSplitByClientAndGap(t *testing.T) {
+	t0 := time.Now()
+	pulls := []storage.PullRecord{
+		{Image: "library/postgres", Tag: "16", ClientIP: "a", Timestamp: t0},
+		{Image: "library/redis", Tag: "7", ClientIP: "b", Timestamp: t0.Add(time.Minute)},
+		{Image: "dpage/pgadmin4", Tag: "latest", ClientIP: "a", Timestamp: t0.Add(5 * time.Minute)},
+		{Image: "library/nginx", Tag: "latest", ClientIP: "a", Timestamp: t0.Add(2 * time.Hour)},
+	}
+
+	sessions := Sessions(pulls, 30*time.Minute)
+	if len(sessions) != 3 {
+		t.Fatalf("Expected 3 sessions, got %d: %v", len(sessions), sessions)
+	}
+	if !sessions[0]["library/postgres:16"] || !sessions[0]["dpage/pgadmin4:latest"] {
+		t.Errorf("Expected postgres and pgadmin4 in the first session, got %v", sessions[0])
+	}
+}
+
+func TestMineRules(t *testing.T) {
+	sessions := []map[string]bool{
+		{"postgres": true, "pgadmin": true, "redis": true},
+		{"postgres": true, "pgadmin": true},
+		{"postgres": true},
+		{"redis": true},
+	}
+
+	rules := MineRules(sessions, 2, 0.5)
+
+	var found bool
+	for _, r := range rules {
+		if r.From == "postgres" && r.To == "pgadmin" {
+			found = true
+			if r.Support != 2 || r.Confidence < 0.66 || r.Confidence > 0.67 {
+				t.Errorf("Unexpected postgres → pgadmin rule %+v", r)
+			}
+		}
+		if r.Support < 2 || r.Confidence < 0.5 {
+			t.Errorf("Rule below thresholds returned: %+v", r)
+		}
+	}
+	if !found {
+		t.Errorf("Expected a postgres → pgadmin rule, got %v", rules)
+	}
+	if rules[0].From != "pgadmin" || rules[0].Confidence != 1 {
+		t.Errorf("Expected pgadmin → postgres (100%%) first, got %+v", rules[0])
+	}
+}
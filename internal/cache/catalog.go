@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// DefaultCatalog is used when no catalog is selected
+const DefaultCatalog = "default"
+
+// builtinCatalogs ship with the binary. Anything defined in the config file
+// or a catalog file with the same name takes precedence.
+var builtinCatalogs = map[string][]string{
+	DefaultCatalog: {
+		"nginx:latest",
+		"alpine:latest",
+		"ubuntu:latest",
+		"postgres:latest",
+		"redis:latest",
+		"node:lts",
+		"python:3.9",
+		"golang:latest",
+		"mysql:8.0",
+		"mongo:latest",
+	},
+}
+
+// Candidate is an image the predictor may suggest even without pull history
+type Candidate struct {
+	Name   string
+	Source string // shown in explanations, e.g. "in catalog ml"
+}
+
+// Catalogs resolves catalog names to images. Catalogs come from, in order of
+// precedence, the config file, <dir>/<name>.yaml files and the built-in list.
+type Catalogs struct {
+	config map[string][]string
+	dir    string
+}
+
+// NewCatalogs creates a resolver. dir may be empty to skip catalog files.
+func NewCatalogs(config map[string][]string, dir string) *Catalogs {
+	return &Catalogs{config: config, dir: dir}
+}
+
+// DefaultCatalogDir returns ~/.registry-mirror/catalogs
+func DefaultCatalogDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".registry-mirror", "catalogs")
+}
+
+// Resolve returns the candidates of the named catalogs, deduplicated. A name
+// ending in .yaml/.yml is read as a catalog file path.
+func (c *Catalogs) Resolve(names []string) ([]Candidate, error) {
+	var candidates []Candidate
+	seen := make(map[string]bool)
+
+	for _, name := range names {
+		images, err := c.images(name)
+		if err != nil {
+			return nil, err
+		}
+
+		label := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(name), ".yaml"), ".yml")
+		for _, img := range images {
+			key := imageKey(img)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			candidates = append(candidates, Candidate{Name: img, Source: "in catalog " + label})
+		}
+	}
+	return candidates, nil
+}
+
+// Names lists every catalog available, sorted
+func (c *Catalogs) Names() []string {
+	set := make(map[string]bool)
+	for name := range builtinCatalogs {
+		set[name] = true
+	}
+	for name := range c.config {
+		set[name] = true
+	}
+	if c.dir != "" {
+		files, _ := filepath.Glob(filepath.Join(c.dir, "*.y*ml"))
+		for _, f := range files {
+			set[strings.TrimSuffix(strings.TrimSuffix(filepath.Base(f), ".yaml"), ".yml")] = true
+		}
+	}
+
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *Catalogs) images(name string) ([]string, error) {
+	if strings.HasSuffix(name, ".yaml") || strings.HasSuffix(name, ".yml") {
+		return loadCatalogFile(name)
+	}
+
+	if images, ok := c.config[name]; ok {
+		return images, nil
+	}
+
+	if c.dir != "" {
+		for _, ext := range []string{".yaml", ".yml"} {
+			path := filepath.Join(c.dir, name+ext)
+			if _, err := os.Stat(path); err == nil {
+				return loadCatalogFile(path)
+			}
+		}
+	}
+
+	if images, ok := builtinCatalogs[name]; ok {
+		return images, nil
+	}
+	return nil, fmt.Errorf("unknown catalog %q (available: %s)", name, strings.Join(c.Names(), ", "))
+}
+
+// loadCatalogFile reads a catalog file, either a plain YAML list of images or
+// a map with an `images` key
+func loadCatalogFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read catalog: %w", err)
+	}
+
+	var list []string
+	if err := yaml.Unmarshal(data, &list); err == nil {
+		return list, nil
+	}
+
+	var doc struct {
+		Images []string `yaml:"images"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid catalog %s: %w", path, err)
+	}
+	return doc.Images, nil
+}
@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCatalogPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "ml.yaml"), []byte("- tensorflow/tensorflow:latest\n- pytorch/pytorch:latest\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "web.yaml"), []byte("images:\n  - caddy:2\n"), 0644)
+
+	catalogs := NewCatalogs(map[string][]string{"web": {"nginx:latest", "node:lts"}}, dir)
+
+	got, err := catalogs.Resolve([]string{"ml", "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 {
+		t.Fatalf("Expected 4 candidates, got %+v", got)
+	}
+	// The config file wins over web.yaml
+	if got[2].Name != "nginx:latest" || got[2].Source != "in catalog web" {
+		t.Errorf("Unexpected candidate %+v", got[2])
+	}
+}
+
+func TestCatalogDeduplicatesAndRejectsUnknown(t *testing.T) {
+	catalogs := NewCatalogs(map[string][]string{"a": {"nginx"}, "b": {"library/nginx:latest", "redis"}}, "")
+
+	got, err := catalogs.Resolve([]string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Errorf("Expected nginx to be deduplicated, got %+v", got)
+	}
+
+	if _, err := catalogs.Resolve([]string{"nope"}); err == nil {
+		t.Error("Expected an error for an unknown catalog")
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package cache
+
+import "fmt"
+
+func freeSpace(path string) (int64, error) {
+	return 0, fmt.Errorf("free space checks are not supported on this platform")
+}
@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// DefaultTTL is how long an unused image is kept under PolicyTTL
+const DefaultTTL = 30 * 24 * time.Hour
+
+// Entry is a cached image as seen by an eviction policy
+type Entry struct {
+	Image    string
+	Synced   time.Time // last completed sync, i.e. when it entered the cache
+	LastPull time.Time // zero if never pulled
+	Pulls    int       // pulls within the last 30 days
+	Size     int64     // total size of the image's blobs
+	Bytes    int64     // bytes evicting only this image frees
+	Pinned   bool
+}
+
+// LastUsed is the latest of the last pull and the last sync
+func (e Entry) LastUsed() time.Time {
+	if e.LastPull.After(e.Synced) {
+		return e.LastPull
+	}
+	return e.Synced
+}
+
+// Policy decides which cached images go first when the cache is over its limit
+type Policy interface {
+	// Less reports whether a should be evicted before b
+	Less(a, b Entry) bool
+	// Expired reports whether an entry should be evicted even when the cache
+	// is under its limit
+	Expired(e Entry) bool
+}
+
+// NewPolicy returns the built-in policy for a type. ttl is only used by PolicyTTL.
+func NewPolicy(t PolicyType, ttl time.Duration) (Policy, error) {
+	switch PolicyType(strings.ToUpper(string(t))) {
+	case PolicyLRU:
+		return lruPolicy{}, nil
+	case PolicyFIFO:
+		return fifoPolicy{}, nil
+	case PolicyLFU:
+		return lfuPolicy{}, nil
+	case PolicySize:
+		return sizePolicy{}, nil
+	case PolicyTTL:
+		if ttl <= 0 {
+			ttl = DefaultTTL
+		}
+		return ttlPolicy{ttl: ttl, now: time.Now}, nil
+	}
+	return nil, fmt.Errorf("unknown cache policy %q (available: LRU, FIFO, LFU, SIZE, TTL)", t)
+}
+
+// lruPolicy evicts the image that was pulled (or synced) longest ago
+type lruPolicy struct{}
+
+func (lruPolicy) Less(a, b Entry) bool { return a.LastUsed().Before(b.LastUsed()) }
+func (lruPolicy) Expired(Entry) bool   { return false }
+
+// fifoPolicy evicts the image that entered the cache first, however often it's used
+type fifoPolicy struct{}
+
+func (fifoPolicy) Less(a, b Entry) bool { return a.Synced.Before(b.Synced) }
+func (fifoPolicy) Expired(Entry) bool   { return false }
+
+// lfuPolicy evicts the least pulled image, least recently used first on ties
+type lfuPolicy struct{}
+
+func (lfuPolicy) Less(a, b Entry) bool {
+	if a.Pulls != b.Pulls {
+		return a.Pulls < b.Pulls
+	}
+	return a.LastUsed().Before(b.LastUsed())
+}
+func (lfuPolicy) Expired(Entry) bool { return false }
+
+// sizePolicy evicts the image with the fewest pulls per byte it frees,
+// (pulls+1) / bytes, so large rarely pulled images go first
+type sizePolicy struct{}
+
+func (sizePolicy) Less(a, b Entry) bool {
+	ha, hb := pullsPerByte(a), pullsPerByte(b)
+	if ha != hb {
+		return ha < hb
+	}
+	return a.LastUsed().Before(b.LastUsed())
+}
+func (sizePolicy) Expired(Entry) bool { return false }
+
+func pullsPerByte(e Entry) float64 {
+	if e.Bytes <= 0 {
+		// Evicting it frees nothing
+		return math.Inf(1)
+	}
+	return float64(e.Pulls+1) / float64(e.Bytes)
+}
+
+// ttlPolicy evicts every image unused for longer than ttl, and falls back to
+// LRU when the cache is still over its limit
+type ttlPolicy struct {
+	ttl time.Duration
+	now func() time.Time
+}
+
+func (ttlPolicy) Less(a, b Entry) bool { return lruPolicy{}.Less(a, b) }
+
+func (p ttlPolicy) Expired(e Entry) bool {
+	return p.now().Sub(e.LastUsed()) > p.ttl
+}
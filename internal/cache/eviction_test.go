@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func evictionOrder(t *testing.T, policyType PolicyType, entries []Entry) []string {
+	policy, err := NewPolicy(policyType, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return policy.Less(entries[i], entries[j]) })
+
+	var order []string
+	for _, e := range entries {
+		order = append(order, e.Image)
+	}
+	return order
+}
+
+func TestPolicyOrder(t *testing.T) {
+	now := time.Now()
+	const mb = 1024 * 1024
+	entries := []Entry{
+		// synced first but pulled all the time
+		{Image: "busy", Synced: now.Add(-72 * time.Hour), LastPull: now.Add(-time.Minute), Pulls: 50, Bytes: 100 * mb},
+		// huge and pulled once
+		{Image: "huge", Synced: now.Add(-48 * time.Hour), LastPull: now.Add(-time.Hour), Pulls: 1, Bytes: 2000 * mb},
+		// small, never pulled since the sync
+		{Image: "small", Synced: now.Add(-24 * time.Hour), Bytes: 5 * mb},
+	}
+
+	tests := []struct {
+		policy PolicyType
+		want   []string
+	}{
+		{PolicyFIFO, []string{"busy", "huge", "small"}},
+		{PolicyLRU, []string{"small", "huge", "busy"}},
+		{PolicyLFU, []string{"small", "huge", "busy"}},
+		{PolicySize, []string{"huge", "small", "busy"}},
+	}
+
+	for _, tt := range tests {
+		got := evictionOrder(t, tt.policy, append([]Entry(nil), entries...))
+		for i := range tt.want {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: expected %v, got %v", tt.policy, tt.want, got)
+				break
+			}
+		}
+	}
+}
+
+func TestTTLPolicyExpiresUnusedImages(t *testing.T) {
+	policy, err := NewPolicy("ttl", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	stale := Entry{Image: "stale", Synced: now.Add(-48 * time.Hour)}
+	pulled := Entry{Image: "pulled", Synced: now.Add(-48 * time.Hour), LastPull: now.Add(-time.Hour)}
+
+	if !policy.Expired(stale) {
+		t.Error("Expected image unused for two days to expire")
+	}
+	if policy.Expired(pulled) {
+		t.Error("Expected recently pulled image to be kept")
+	}
+}
+
+func TestUnknownPolicy(t *testing.T) {
+	if _, err := NewPolicy("random", 0); err == nil {
+		t.Error("Expected error for unknown policy")
+	}
+}
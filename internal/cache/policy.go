@@ -1,91 +1,655 @@
 package cache
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/saurabh12nxf/registry-mirror/internal/blobstore"
+	"github.com/saurabh12nxf/registry-mirror/internal/registry"
 	"github.com/saurabh12nxf/registry-mirror/internal/storage"
 )
 
 type PolicyType string
 
 const (
-	PolicyLRU  PolicyType = "LRU"
-	PolicyFIFO PolicyType = "FIFO"
+	PolicyLRU  PolicyType = "LRU"  // least recently pulled or synced
+	PolicyFIFO PolicyType = "FIFO" // first synced
+	PolicyLFU  PolicyType = "LFU"  // least pulled
+	PolicySize PolicyType = "SIZE" // fewest pulls per byte
+	PolicyTTL  PolicyType = "TTL"  // unused for longer than a TTL
 )
 
 type Manager struct {
-	db      *storage.DB
+	db      storage.DB
 	maxSize int64 // in bytes
 	policy  PolicyType
+	evictor Policy
+	store   *blobstore.Store
+	client  *registry.Client
+	pinned  []string // from the config file, on top of the pins in the database
+	gc      func(ctx context.Context) error
+
+	diskPath  string // filesystem to keep minFree bytes free on, if set
+	minFree   int64
+	freeSpace func(path string) (int64, error)
 }
 
-func NewManager(db *storage.DB, maxSizeMB int64, policy PolicyType) *Manager {
+// NewManager creates a cache manager. An unknown policy falls back to LRU;
+// use NewPolicy to validate user input first.
+func NewManager(db storage.DB, maxSizeMB int64, policy PolicyType) *Manager {
+	evictor, err := NewPolicy(policy, DefaultTTL)
+	if err != nil {
+		evictor = lruPolicy{}
+	}
 	return &Manager{
-		db:      db,
-		maxSize: maxSizeMB * 1024 * 1024,
-		policy:  policy,
+		db:        db,
+		maxSize:   maxSizeMB * 1024 * 1024,
+		policy:    policy,
+		evictor:   evictor,
+		freeSpace: freeSpace,
 	}
 }
 
-// Clean returns a list of images that should be deleted to free up space
-// Note: In this version we just identify them, actual deletion would require registry API deletion support
+// Limit returns the configured cache size limit in bytes
+func (m *Manager) Limit() int64 {
+	return m.maxSize
+}
+
+// PolicyType returns the name of the eviction policy
+func (m *Manager) PolicyType() PolicyType {
+	return m.policy
+}
+
+// SetPolicy replaces the eviction policy, e.g. a TTL policy with a custom TTL
+func (m *Manager) SetPolicy(p Policy) {
+	m.evictor = p
+}
+
+// SetPinned adds images that must never be evicted, e.g. the config file's
+// pinned list. Images pinned with Pin are honored as well.
+func (m *Manager) SetPinned(images []string) {
+	m.pinned = images
+}
+
+// Pin protects an image from eviction under every policy
+func (m *Manager) Pin(image string) error {
+	return m.db.PinImage(image)
+}
+
+// Unpin removes the database pins matching an image. It returns false if
+// there were none; pins from the config file can only be removed there.
+func (m *Manager) Unpin(image string) (bool, error) {
+	pins, err := m.db.GetPinnedImages()
+	if err != nil {
+		return false, err
+	}
+
+	removed := false
+	for _, p := range pins {
+		if imageKey(p) != imageKey(image) {
+			continue
+		}
+		ok, err := m.db.UnpinImage(p)
+		if err != nil {
+			return removed, err
+		}
+		removed = removed || ok
+	}
+	return removed, nil
+}
+
+// Pinned returns every pinned image, from the config file and the database
+func (m *Manager) Pinned() ([]string, error) {
+	pins, err := m.db.GetPinnedImages()
+	if err != nil {
+		return nil, err
+	}
+
+	var images []string
+	seen := make(map[string]bool)
+	for _, img := range append(append([]string(nil), m.pinned...), pins...) {
+		if key := imageKey(img); !seen[key] {
+			seen[key] = true
+			images = append(images, img)
+		}
+	}
+	sort.Strings(images)
+	return images, nil
+}
+
+// IsPinned reports whether an image is pinned
+func (m *Manager) IsPinned(image string) (bool, error) {
+	pinned, err := m.pinnedKeys()
+	if err != nil {
+		return false, err
+	}
+	return pinned[imageKey(image)], nil
+}
+
+func (m *Manager) pinnedKeys() (map[string]bool, error) {
+	pins, err := m.Pinned()
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]bool, len(pins))
+	for _, p := range pins {
+		keys[imageKey(p)] = true
+	}
+	return keys, nil
+}
+
+// UseBlobStore makes eviction release images from the local blob store too
+func (m *Manager) UseBlobStore(store *blobstore.Store) {
+	m.store = store
+}
+
+// UseRegistry gives the manager a local registry to delete evicted images from
+func (m *Manager) UseRegistry(client *registry.Client) {
+	m.client = client
+}
+
+// UseGarbageCollector gives the manager a way to run the registry's garbage
+// collector, which is what actually frees the disk space of evicted images
+func (m *Manager) UseGarbageCollector(gc func(ctx context.Context) error) {
+	m.gc = gc
+}
+
+// Usage returns the bytes the local registry holds, counting every blob once
+// however many images share it
+func (m *Manager) Usage() (int64, error) {
+	_, used, err := m.db.GetCacheUsage()
+	return used, err
+}
+
+// Freed returns how many bytes evicting all the given images would free,
+// i.e. the size of the blobs no other image references. Pinned images are
+// never evicted, so they free nothing.
+func (m *Manager) Freed(images []string) (int64, error) {
+	inv, err := m.snapshot()
+	if err != nil {
+		return 0, err
+	}
+	t := inv.tally()
+	for _, img := range images {
+		t.evict(img)
+	}
+	return t.freed, nil
+}
+
+// inventory is a snapshot of the pins and of which blobs every tag in the
+// local registry references, to work out what evictions free in memory
+type inventory struct {
+	pinned map[string]bool              // by image key
+	blobs  map[string][]storage.BlobRef // by repository:tag
+	users  map[string]int               // tags referencing a blob, by digest
+	tags   map[string]storage.TagRecord // by repository:tag
+	shared map[string][]string          // tags by repository@manifest digest
+}
+
+func (m *Manager) snapshot() (*inventory, error) {
+	pinned, err := m.pinnedKeys()
+	if err != nil {
+		return nil, err
+	}
+	tags, err := m.db.GetTags("")
+	if err != nil {
+		return nil, err
+	}
+	blobs, err := m.db.GetTagBlobs()
+	if err != nil {
+		return nil, err
+	}
+
+	inv := &inventory{
+		pinned: pinned,
+		blobs:  blobs,
+		users:  make(map[string]int),
+		tags:   make(map[string]storage.TagRecord),
+		shared: make(map[string][]string),
+	}
+	for _, refs := range blobs {
+		for _, b := range refs {
+			inv.users[b.Digest]++
+		}
+	}
+	for _, t := range tags {
+		inv.tags[t.Ref()] = t
+		key := t.Repository + "@" + t.Manifest.Digest
+		inv.shared[key] = append(inv.shared[key], t.Ref())
+	}
+	return inv, nil
+}
+
+// group returns an image with the other tags of its manifest, which
+// evicting it removes too
+func (inv *inventory) group(image string) []string {
+	ref := localRef(image)
+	refs := []string{ref}
+	t, ok := inv.tags[ref]
+	if !ok {
+		return refs
+	}
+	for _, other := range inv.shared[t.Repository+"@"+t.Manifest.Digest] {
+		if other != ref {
+			refs = append(refs, other)
+		}
+	}
+	return refs
+}
+
+// tally adds up what evicting a growing set of images frees
+type tally struct {
+	inv     *inventory
+	evicted map[string]bool
+	dropped map[string]int // evicted tags referencing a blob, by digest
+	freed   int64
+}
+
+func (inv *inventory) tally() *tally {
+	return &tally{inv: inv, evicted: make(map[string]bool), dropped: make(map[string]int)}
+}
+
+// evict adds an image and the other tags of its manifest, unless one of
+// them is pinned. A blob is freed once every tag referencing it is evicted.
+func (t *tally) evict(image string) {
+	refs := t.inv.group(image)
+	if anyPinned(refs, t.inv.pinned) {
+		return
+	}
+	for _, ref := range refs {
+		if t.evicted[ref] {
+			continue
+		}
+		t.evicted[ref] = true
+		for _, b := range t.inv.blobs[ref] {
+			t.dropped[b.Digest]++
+			if t.dropped[b.Digest] == t.inv.users[b.Digest] {
+				t.freed += b.Size
+			}
+		}
+	}
+}
+
+// Clean returns the images that should be evicted, in the order the policy
+// picks them, and how many bytes that would free. Expired images are always
+// included, the rest only while the cache is over its size limit or the disk
+// is below its free space watermark.
 func (m *Manager) Clean() ([]string, int64, error) {
-	// 1. Get current usage
-	stats, err := m.db.GetAggregatedStats()
+	deficit, err := m.deficit(0)
 	if err != nil {
 		return nil, 0, err
 	}
+	return m.plan(deficit)
+}
+
+// WatchDisk makes the manager keep at least minFreeMB free on the filesystem
+// holding path, typically the registry's storage directory
+func (m *Manager) WatchDisk(path string, minFreeMB int64) {
+	m.diskPath = path
+	m.minFree = minFreeMB * 1024 * 1024
+}
+
+// FreeSpace returns the free bytes on the watched filesystem, or -1 when no
+// disk is watched
+func (m *Manager) FreeSpace() (int64, error) {
+	if m.diskPath == "" {
+		return -1, nil
+	}
+	return m.freeSpace(m.diskPath)
+}
 
-	if stats.TotalBytes <= m.maxSize {
-		return nil, 0, nil // Under limit, no action needed
+// deficit returns how many bytes must be freed so that adding incoming bytes
+// keeps the cache within its limit and the disk above its watermark
+func (m *Manager) deficit(incoming int64) (int64, error) {
+	used, err := m.Usage()
+	if err != nil {
+		return 0, err
 	}
+	deficit := used + incoming - m.maxSize
 
-	// 2. We are over limit, find what to delete
-	bytesToFree := stats.TotalBytes - m.maxSize
+	if m.diskPath != "" {
+		free, err := m.freeSpace(m.diskPath)
+		if err != nil {
+			return 0, fmt.Errorf("failed to check free space on %s: %w", m.diskPath, err)
+		}
+		if d := m.minFree - (free - incoming); d > deficit {
+			deficit = d
+		}
+	}
+	return deficit, nil
+}
 
-	// Get all images sorted by last access (oldest first for LRU)
-	records, err := m.db.GetRecentSyncs(1000) // simplified: just get recent ones
+// plan picks the images to evict to free bytesToFree, plus every expired one
+func (m *Manager) plan(bytesToFree int64) ([]string, int64, error) {
+	inv, err := m.snapshot()
 	if err != nil {
 		return nil, 0, err
 	}
+	entries, err := m.entries(inv)
+	if err != nil {
+		return nil, 0, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return m.evictor.Less(entries[i], entries[j]) })
 
-	// Sort based on policy
-	// Note: GetRecentSyncs returns Newest -> Oldest.
-	// For LRU we want to delete Oldest (end of list), but "RecentSyncs" might not capture unique images correctly if there are duplicates.
-	// For a real LRU implementation we'd need a "LastAccess" table.
-	// We'll stick to a simple strategy: Delete the oldest sync records.
+	var candidates []string
+	// Layers shared with an image evicted earlier in the plan are only
+	// freed once both are gone
+	freed := inv.tally()
 
-	// Actually, let's just implement a simple "suggest cleanup" for now since we can't easily delete from registry in this simple CLI.
+	for _, e := range entries {
+		if e.Pinned {
+			continue
+		}
+		if !m.evictor.Expired(e) && freed.freed >= bytesToFree {
+			continue
+		}
+		candidates = append(candidates, e.Image)
+		freed.evict(e.Image)
+	}
 
-	var candidates []string
-	var predictedFreed int64 = 0
+	return candidates, freed.freed, nil
+}
+
+// SpaceError is returned by CheckFits when an image doesn't fit in the cache
+type SpaceError struct {
+	Image    string
+	Incoming int64    // bytes the sync would add
+	Needed   int64    // bytes that must be freed first
+	Evict    []string // what the policy would evict to make room
+	Freed    int64    // what evicting them frees
+}
+
+func (e *SpaceError) Error() string {
+	mb := func(b int64) float64 { return float64(b) / (1024 * 1024) }
+	msg := fmt.Sprintf("syncing %s adds %.2f MB, %.2f MB more than the cache has room for", e.Image, mb(e.Incoming), mb(e.Needed))
+	if e.Freed < e.Needed {
+		return msg + fmt.Sprintf("; evicting every unpinned image would only free %.2f MB", mb(e.Freed))
+	}
+	return msg + fmt.Sprintf("; evict first with: registry-mirror cache evict %s (frees %.2f MB)", strings.Join(e.Evict, " "), mb(e.Freed))
+}
+
+// CheckFits returns a *SpaceError if syncing an image with the given blobs
+// would push the cache past its limit or the disk below its watermark. Blobs
+// the registry already holds don't count.
+func (m *Manager) CheckFits(image string, blobs []storage.BlobRef) error {
+	incoming, err := m.db.GetMissingBytes(blobs)
+	if err != nil {
+		return err
+	}
+
+	deficit, err := m.deficit(incoming)
+	if err != nil {
+		return err
+	}
+	if deficit <= 0 {
+		return nil
+	}
+
+	evict, freed, err := m.plan(deficit)
+	if err != nil {
+		return err
+	}
+	return &SpaceError{Image: image, Incoming: incoming, Needed: deficit, Evict: evict, Freed: freed}
+}
+
+// Entries returns every image in the local registry with the usage data
+// eviction policies rank on, least recently synced first
+func (m *Manager) Entries() ([]Entry, error) {
+	inv, err := m.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return m.entries(inv)
+}
+
+func (m *Manager) entries(inv *inventory) ([]Entry, error) {
+	// Only images still in the registry, i.e. not evicted since their last sync
+	records, err := m.db.GetCachedImages()
+	if err != nil {
+		return nil, err
+	}
+
+	pulls, err := m.db.GetPulls(time.Now().Add(-pullWindow))
+	if err != nil {
+		return nil, err
+	}
+	type usage struct {
+		count int
+		last  time.Time
+	}
+	byKey := make(map[string]*usage)
+	for _, p := range pulls {
+		key := imageKey(p.Image + ":" + p.Tag)
+		u, ok := byKey[key]
+		if !ok {
+			u = &usage{}
+			byKey[key] = u
+		}
+		u.count++
+		if p.Timestamp.After(u.last) {
+			u.last = p.Timestamp
+		}
+	}
+
+	entries := make([]Entry, 0, len(records))
+	sizes, err := m.db.GetImageSizes()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rec := range records {
+		alone := inv.tally()
+		alone.evict(rec.Image)
+		e := Entry{
+			Image:  rec.Image,
+			Synced: rec.Timestamp,
+			Size:   sizes[localRef(rec.Image)],
+			Bytes:  alone.freed,
+			Pinned: inv.pinned[imageKey(rec.Image)],
+		}
+		if u, ok := byKey[imageKey(rec.Image)]; ok {
+			e.Pulls = u.count
+			e.LastPull = u.last
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Evict deletes an image's manifest from the local registry, marks it evicted
+// and releases its blobs in the blob store. The registry only reclaims the
+// layers once its garbage collector runs.
+//
+// Manifests are deleted by digest, which removes every tag pointing at it, so
+// the other tags of the same manifest are evicted too. Evict returns every
+// image it evicted, and refuses if one of them is pinned.
+func (m *Manager) Evict(ctx context.Context, image string) ([]string, error) {
+	if pinned, err := m.IsPinned(image); err != nil {
+		return nil, err
+	} else if pinned {
+		return nil, fmt.Errorf("%s is pinned, unpin it first", image)
+	}
+	if m.client == nil {
+		return nil, fmt.Errorf("no registry to evict %s from", image)
+	}
+
+	name, tag := registry.ParseReference(image)
+	var digest string
+	raw, err := m.client.GetLocalManifest(ctx, name, tag)
+	if err != nil {
+		var statusErr *registry.StatusError
+		if !errors.As(err, &statusErr) || statusErr.Code != http.StatusNotFound {
+			return nil, err
+		}
+		// Already gone from the registry, just bring our records in line
+		if t, err := m.db.GetTag(name, tag); err != nil {
+			return nil, err
+		} else if t != nil {
+			digest = t.Manifest.Digest
+		}
+	} else {
+		digest = raw.Digest
+	}
+
+	evicted := []string{image}
+	if digest != "" {
+		siblings, err := m.sharedTags(name, tag, digest)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range siblings {
+			if pinned, err := m.IsPinned(ref); err != nil {
+				return nil, err
+			} else if pinned {
+				return nil, fmt.Errorf("%s is the same manifest as pinned %s, evicting one deletes both", image, ref)
+			}
+		}
+		evicted = append(evicted, siblings...)
+	}
+
+	if raw != nil {
+		if err := m.client.DeleteManifest(ctx, name, raw.Digest); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, ref := range evicted {
+		if err := m.forget(ref); err != nil {
+			return nil, err
+		}
+	}
+	return evicted, nil
+}
+
+func anyPinned(images []string, pinned map[string]bool) bool {
+	for _, img := range images {
+		if pinned[imageKey(img)] {
+			return true
+		}
+	}
+	return false
+}
 
-	// Walk backwards (Oldest first)
-	for i := len(records) - 1; i >= 0; i-- {
-		rec := records[i]
-		candidates = append(candidates, rec.Image)
-		predictedFreed += rec.Bytes
+// sharedTags returns the other tags of a repository pointing at a manifest
+func (m *Manager) sharedTags(name, tag, digest string) ([]string, error) {
+	tags, err := m.db.GetTags(name)
+	if err != nil {
+		return nil, err
+	}
+	var refs []string
+	for _, t := range tags {
+		if t.Tag != tag && t.Repository == name && t.Manifest.Digest == digest {
+			refs = append(refs, t.Ref())
+		}
+	}
+	return refs, nil
+}
 
-		if predictedFreed >= bytesToFree {
-			break
+// forget marks an image evicted, under every name it was synced as, and
+// removes it from the inventory and the blob store
+func (m *Manager) forget(image string) error {
+	cached, err := m.db.GetCachedImages()
+	if err != nil {
+		return err
+	}
+	names := []string{image}
+	for _, rec := range cached {
+		if rec.Image != image && imageKey(rec.Image) == imageKey(image) {
+			names = append(names, rec.Image)
+		}
+	}
+	for _, n := range names {
+		if err := m.db.MarkEvicted(n); err != nil {
+			return fmt.Errorf("failed to mark %s evicted: %w", n, err)
 		}
 	}
 
-	return candidates, predictedFreed, nil
+	name, tag := registry.ParseReference(image)
+	if err := m.db.RemoveImage(name, tag); err != nil {
+		return fmt.Errorf("failed to release %s: %w", image, err)
+	}
+	if m.store != nil {
+		if err := m.store.Release(image); err != nil {
+			return fmt.Errorf("failed to release %s from blob store: %w", image, err)
+		}
+	}
+	return nil
 }
 
+// EnforcePolicy suggests what to evict when the cache is over its limit. When
+// the watched disk is below its watermark and both a registry and a garbage
+// collector are configured, it evicts and collects straight away instead.
+// Without the garbage collector evicting frees no disk space, so the next
+// check would find the same shortfall and evict the next images in line.
 func (m *Manager) EnforcePolicy() error {
 	candidates, freed, err := m.Clean()
 	if err != nil {
 		return err
 	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	lowDisk := false
+	if m.diskPath != "" {
+		if free, err := m.freeSpace(m.diskPath); err == nil && free < m.minFree {
+			lowDisk = true
+			if m.client != nil && m.gc != nil {
+				return m.evictAndCollect(candidates, free)
+			}
+		}
+	}
+
+	fmt.Printf("🧹 Cache policy (%s) triggered. Suggested cleanup:\n", m.policy)
+	for _, img := range candidates {
+		fmt.Printf("   - %s\n", img)
+	}
+	fmt.Printf("   (Would free approx %.2f MB)\n", float64(freed)/(1024*1024))
+	fmt.Println("   Run 'registry-mirror cache evict --gc-container <registry-container>' to delete them.")
+	if lowDisk {
+		fmt.Println("   Set cache.gc_container to do this automatically when the disk runs low.")
+	}
+	return nil
+}
 
-	if len(candidates) > 0 {
-		fmt.Printf("🧹 Cache policy (%s) triggered. Suggested cleanup:\n", m.policy)
-		for _, img := range candidates {
-			fmt.Printf("   - %s\n", img)
+// evictAndCollect evicts the candidates and runs the garbage collector
+func (m *Manager) evictAndCollect(candidates []string, free int64) error {
+	ctx := context.Background()
+	fmt.Printf("🚨 Only %.2f MB free on %s, evicting:\n", float64(free)/(1024*1024), m.diskPath)
+	evicted := 0
+	done := make(map[string]bool)
+	for _, img := range candidates {
+		if done[imageKey(img)] {
+			continue
 		}
-		fmt.Printf("   (Would free approx %.2f MB)\n", float64(freed)/(1024*1024))
+		refs, err := m.Evict(ctx, img)
+		if err != nil {
+			fmt.Printf("   ❌ %s: %v\n", img, err)
+			continue
+		}
+		for _, ref := range refs {
+			done[imageKey(ref)] = true
+			fmt.Printf("   🗑️  %s\n", ref)
+		}
+		evicted++
+	}
+	if evicted == 0 {
+		return nil
 	}
+	if err := m.gc(ctx); err != nil {
+		return fmt.Errorf("registry garbage collection failed: %w", err)
+	}
+	fmt.Println("   ♻️  Registry garbage collection complete")
 	return nil
 }
+
+// localRef returns repository:tag as the image is named in the local registry
+func localRef(image string) string {
+	name, tag := registry.ParseReference(image)
+	return name + ":" + tag
+}
@@ -1,7 +1,15 @@
 package cache
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/registry"
+	"github.com/saurabh12nxf/registry-mirror/internal/storage"
 )
 
 func TestNewManager(t *testing.T) {
@@ -25,3 +33,273 @@ func TestPolicyTypes(t *testing.T) {
 		t.Error("FIFO constant mismatch")
 	}
 }
+
+func TestEvictDeletesManifestAndMarksEvicted(t *testing.T) {
+	db, err := storage.Open("memory://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var deleted string
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/v2/nginx/manifests/latest":
+			w.Header().Set("Docker-Content-Digest", "sha256:abc")
+			w.Write([]byte(`{"schemaVersion":2}`))
+		case r.Method == "DELETE":
+			deleted = r.URL.Path
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer reg.Close()
+
+	db.RecordSync(storage.SyncRecord{Image: "nginx:latest", Status: "completed", Bytes: 100, Duration: 1})
+	db.RecordSync(storage.SyncRecord{Image: "redis:latest", Status: "completed", Bytes: 100, Duration: 1})
+
+	mgr := NewManager(db, 0, PolicyLRU)
+	mgr.UseRegistry(registry.NewClient(strings.TrimPrefix(reg.URL, "http://")))
+
+	if _, err := mgr.Evict(context.Background(), "nginx:latest"); err != nil {
+		t.Fatal(err)
+	}
+	if deleted != "/v2/nginx/manifests/sha256:abc" {
+		t.Errorf("Expected delete by digest, got %q", deleted)
+	}
+
+	cached, err := db.GetCachedImages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cached) != 1 || cached[0].Image != "redis:latest" {
+		t.Errorf("Expected only redis:latest to remain cached, got %v", cached)
+	}
+}
+
+func TestEvictTakesOtherTagsOfTheManifest(t *testing.T) {
+	db, err := storage.Open("memory://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	deletes := 0
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			w.Header().Set("Docker-Content-Digest", "sha256:m125")
+			w.Write([]byte(`{"schemaVersion":2}`))
+		case "DELETE":
+			deletes++
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer reg.Close()
+
+	const mb = 1024 * 1024
+	layer := []storage.BlobRef{{Digest: "sha256:layer", Size: 100 * mb}}
+	for _, tag := range []string{"1.25", "latest"} {
+		db.RecordSync(storage.SyncRecord{Image: "nginx:" + tag, Status: "completed", Bytes: 0, Duration: 1})
+		db.RecordImage("nginx", tag, storage.ManifestRecord{Digest: "sha256:m125"}, layer)
+	}
+
+	mgr := NewManager(db, 1000, PolicyLRU)
+	mgr.UseRegistry(registry.NewClient(strings.TrimPrefix(reg.URL, "http://")))
+
+	// The layer is only freed because latest goes too
+	if freed, _ := mgr.Freed([]string{"nginx:1.25"}); freed != 100*mb {
+		t.Errorf("Expected evicting nginx:1.25 to free 100 MB, got %d MB", freed/mb)
+	}
+
+	if err := mgr.Pin("nginx:latest"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mgr.Evict(context.Background(), "nginx:1.25"); err == nil || deletes != 0 {
+		t.Errorf("Expected a pinned tag of the same manifest to block eviction, got %v", err)
+	}
+	mgr.Unpin("nginx:latest")
+
+	evicted, err := mgr.Evict(context.Background(), "nginx:1.25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evicted) != 2 || evicted[1] != "nginx:latest" {
+		t.Errorf("Expected both tags evicted, got %v", evicted)
+	}
+	if cached, _ := db.GetCachedImages(); len(cached) != 0 {
+		t.Errorf("Expected no cached images left, got %v", cached)
+	}
+	if tags, _ := db.GetTags(""); len(tags) != 0 {
+		t.Errorf("Expected both tags gone from the inventory, got %v", tags)
+	}
+}
+
+func TestCleanCountsSharedLayersOnce(t *testing.T) {
+	db, err := storage.Open("memory://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	const mb = 1024 * 1024
+	base := storage.BlobRef{Digest: "sha256:base", Size: 60 * mb}
+	db.RecordSync(storage.SyncRecord{Image: "app-a:1", Status: "completed", Bytes: 0, Duration: 1})
+	db.RecordImage("app-a", "1", storage.ManifestRecord{Digest: "sha256:ma"}, []storage.BlobRef{base, {Digest: "sha256:a", Size: 10 * mb}})
+	db.RecordSync(storage.SyncRecord{Image: "app-b:1", Status: "completed", Bytes: 0, Duration: 1})
+	db.RecordImage("app-b", "1", storage.ManifestRecord{Digest: "sha256:mb"}, []storage.BlobRef{base, {Digest: "sha256:b", Size: 20 * mb}})
+
+	mgr := NewManager(db, 50, PolicyLRU)
+
+	used, err := mgr.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used != 90*mb {
+		t.Errorf("Expected 90 MB in use, got %d MB", used/mb)
+	}
+
+	// Evicting app-a alone frees only its own 10 MB, the base layer goes
+	// once app-b is evicted as well
+	candidates, freed, err := mgr.Clean()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 2 || freed != 90*mb {
+		t.Errorf("Expected both images freeing 90 MB, got %v freeing %d MB", candidates, freed/mb)
+	}
+
+	if freed, _ := mgr.Freed([]string{"app-a:1"}); freed != 10*mb {
+		t.Errorf("Expected app-a to free 10 MB on its own, got %d MB", freed/mb)
+	}
+}
+
+func TestPinnedImagesAreNeverEvicted(t *testing.T) {
+	db, err := storage.Open("memory://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	const mb = 1024 * 1024
+	for _, img := range []string{"postgres:15", "ci-base:1", "old:1"} {
+		name, tag := registry.ParseReference(img)
+		db.RecordSync(storage.SyncRecord{Image: img, Status: "completed", Bytes: 0, Duration: 1})
+		db.RecordImage(name, tag, storage.ManifestRecord{Digest: "sha256:m-" + img}, []storage.BlobRef{{Digest: "sha256:" + img, Size: 100 * mb}})
+	}
+
+	mgr := NewManager(db, 0, PolicyLRU)
+	mgr.SetPinned([]string{"library/postgres:15"})
+	if err := mgr.Pin("ci-base:1"); err != nil {
+		t.Fatal(err)
+	}
+
+	candidates, freed, err := mgr.Clean()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || candidates[0] != "old:1" || freed != 100*mb {
+		t.Errorf("Expected only old:1 freeing 100 MB, got %v freeing %d MB", candidates, freed/mb)
+	}
+
+	if freed, _ := mgr.Freed([]string{"postgres:15", "old:1"}); freed != 100*mb {
+		t.Errorf("Expected pinned image to free nothing, got %d MB", freed/mb)
+	}
+
+	if _, err := mgr.Evict(context.Background(), "postgres:15"); err == nil || !strings.Contains(err.Error(), "pinned") {
+		t.Errorf("Expected evicting a pinned image to fail, got %v", err)
+	}
+}
+
+func TestCheckFitsReportsWhatToEvict(t *testing.T) {
+	db, err := storage.Open("memory://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	const mb = 1024 * 1024
+	db.RecordSync(storage.SyncRecord{Image: "old:1", Status: "completed", Bytes: 0, Duration: 1})
+	db.RecordImage("old", "1", storage.ManifestRecord{Digest: "sha256:m1"}, []storage.BlobRef{{Digest: "sha256:old", Size: 300 * mb}})
+
+	mgr := NewManager(db, 1000, PolicyLRU)
+	mgr.WatchDisk("/registry", 100)
+	free := int64(500 * mb)
+	mgr.freeSpace = func(string) (int64, error) { return free, nil }
+
+	if err := mgr.CheckFits("small:1", []storage.BlobRef{{Digest: "sha256:small", Size: 100 * mb}}); err != nil {
+		t.Errorf("Expected 100 MB image to fit, got %v", err)
+	}
+
+	// Within the 1000 MB limit but would leave only 50 MB free on disk
+	err = mgr.CheckFits("big:1", []storage.BlobRef{{Digest: "sha256:big", Size: 450 * mb}})
+	var spaceErr *SpaceError
+	if !errors.As(err, &spaceErr) {
+		t.Fatalf("Expected a SpaceError, got %v", err)
+	}
+	if spaceErr.Needed != 50*mb || len(spaceErr.Evict) != 1 || spaceErr.Evict[0] != "old:1" {
+		t.Errorf("Expected to need 50 MB by evicting old:1, got %+v", spaceErr)
+	}
+
+	// Blobs the registry already holds don't count
+	if err := mgr.CheckFits("old:2", []storage.BlobRef{{Digest: "sha256:old", Size: 300 * mb}}); err != nil {
+		t.Errorf("Expected image sharing cached layers to fit, got %v", err)
+	}
+
+	free = 50 * mb
+	candidates, _, err := mgr.Clean()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 {
+		t.Errorf("Expected low disk space to trigger eviction, got %v", candidates)
+	}
+}
+
+func TestEnforcePolicyEvictsOnlyWithGarbageCollector(t *testing.T) {
+	db, err := storage.Open("memory://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	deletes := 0
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			w.Header().Set("Docker-Content-Digest", "sha256:m1")
+			w.Write([]byte(`{"schemaVersion":2}`))
+		case "DELETE":
+			deletes++
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer reg.Close()
+
+	const mb = 1024 * 1024
+	db.RecordSync(storage.SyncRecord{Image: "old:1", Status: "completed", Bytes: 0, Duration: 1})
+	db.RecordImage("old", "1", storage.ManifestRecord{Digest: "sha256:m1"}, []storage.BlobRef{{Digest: "sha256:old", Size: 300 * mb}})
+
+	mgr := NewManager(db, 1000, PolicyLRU)
+	mgr.UseRegistry(registry.NewClient(strings.TrimPrefix(reg.URL, "http://")))
+	mgr.WatchDisk("/registry", 100)
+	mgr.freeSpace = func(string) (int64, error) { return 50 * mb, nil }
+
+	// Deleting the manifest alone frees nothing on disk
+	if err := mgr.EnforcePolicy(); err != nil {
+		t.Fatal(err)
+	}
+	if deletes != 0 {
+		t.Errorf("Expected no eviction without a garbage collector, got %d deletes", deletes)
+	}
+
+	collected := false
+	mgr.UseGarbageCollector(func(context.Context) error { collected = true; return nil })
+	if err := mgr.EnforcePolicy(); err != nil {
+		t.Fatal(err)
+	}
+	if deletes != 1 || !collected {
+		t.Errorf("Expected old:1 evicted and collected, got %d deletes, collected %v", deletes, collected)
+	}
+}
@@ -1,68 +1,322 @@
 package cache
 
 import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/registry"
 	"github.com/saurabh12nxf/registry-mirror/internal/storage"
 )
 
+const (
+	// pullWindow is how far back pull history is considered
+	pullWindow = 30 * 24 * time.Hour
+
+	// recentWindow is the period quoted in explanations ("pulled 42× in the last 7 days")
+	recentWindow = 7 * 24 * time.Hour
+
+	// DefaultHalfLife is how long it takes for a pull to count half as much
+	DefaultHalfLife = 72 * time.Hour
+
+	// checksPerSuggestion caps the upstream requests of PredictTopImages at
+	// this many per requested suggestion
+	checksPerSuggestion = 5
+
+	// tagMaxAge is how long a mirrored tag is taken to match upstream before
+	// its digest is checked again
+	tagMaxAge = 24 * time.Hour
+)
+
 type Predictor struct {
-	db *storage.DB
+	db         storage.DB
+	client     *registry.Client
+	halfLife   time.Duration
+	now        func() time.Time
+	candidates []Candidate
+}
+
+func NewPredictor(db storage.DB) *Predictor {
+	candidates, _ := NewCatalogs(nil, "").Resolve([]string{DefaultCatalog})
+	return &Predictor{db: db, halfLife: DefaultHalfLife, now: time.Now, candidates: candidates}
+}
+
+// SetCandidates replaces the default catalog with the given images, e.g.
+// selected catalogs or images discovered in a project. Usage data is merged
+// on top: pulled images are always considered.
+func (p *Predictor) SetCandidates(candidates []Candidate) {
+	p.candidates = candidates
 }
 
-func NewPredictor(db *storage.DB) *Predictor {
-	return &Predictor{db: db}
+// SetHalfLife changes how quickly old pulls stop mattering
+func (p *Predictor) SetHalfLife(d time.Duration) {
+	if d > 0 {
+		p.halfLife = d
+	}
+}
+
+// UseRegistry lets the predictor check mirrored tags against Docker Hub once
+// they're older than a day, so tags that moved upstream are suggested again and
+// ranked by how much of them is missing. Without it only local data is used.
+func (p *Predictor) UseRegistry(client *registry.Client) {
+	p.client = client
 }
 
 type PopularImage struct {
 	Name      string
-	PullCount int
+	Source    string // where a candidate without pulls came from
+	PullCount int    // pulls within the last 7 days
+
+	Score         float64
+	LastPull      time.Time
+	MissingLayers int
+	MissingBytes  int64
+	TotalBytes    int64
+	Mirrored      bool // the local registry holds the tag
+	Checked       bool // Mirrored, MissingLayers and MissingBytes are known
+}
+
+// Explain describes why an image was suggested,
+// e.g. "pulled 42× in the last 7 days, 3 missing layers, 180 MB"
+func (img PopularImage) Explain() string {
+	var parts []string
+	if img.PullCount > 0 {
+		parts = append(parts, fmt.Sprintf("pulled %d× in the last 7 days", img.PullCount))
+	} else if !img.LastPull.IsZero() {
+		parts = append(parts, fmt.Sprintf("last pulled %s ago", time.Since(img.LastPull).Round(time.Hour)))
+	} else if img.Source != "" {
+		parts = append(parts, img.Source)
+	} else {
+		parts = append(parts, "popular image")
+	}
+
+	if img.Checked {
+		if !img.Mirrored {
+			parts = append(parts, "not mirrored yet")
+		} else {
+			parts = append(parts, fmt.Sprintf("%d missing layers", img.MissingLayers))
+		}
+		if img.TotalBytes > 0 {
+			parts = append(parts, fmt.Sprintf("%.0f MB", float64(img.MissingBytes)/(1024*1024)))
+		}
+	}
+	return strings.Join(parts, ", ")
 }
 
-// PredictTopImages analyzes usage patterns to suggest what should be mirrored
+// PredictTopImages analyzes usage patterns to suggest what should be mirrored.
+//
+// Each candidate is scored as
+//
+//	decayed pulls × (1 + recency) + missing fraction
+//
+// where every pull counts 0.5^(age/halfLife), recency is the same decay
+// applied to the latest pull, and missing fraction is the share of the
+// image's bytes not yet in the local registry: 1 for an image that isn't
+// mirrored, and for a mirrored tag that moved upstream the share of the new
+// manifest's layers the old one doesn't have (only known with UseRegistry).
 func (p *Predictor) PredictTopImages(limit int) ([]PopularImage, error) {
-	// In a real scenario, this would check a 'pulls' table to see what is requested often but NOT yet mirrored.
-	// Since we only track what we've already synced, we'll simulate "Discovery" by checking for
-	// recurrent patterns or just returning a static list of "Must Have" images for this version.
-
-	// Let's implement a feature that suggests images based on hardcoded "Popular" lists for now,
-	// intersecting with what we already have.
-
-	commonImages := []string{
-		"nginx:latest",
-		"alpine:latest",
-		"ubuntu:latest",
-		"postgres:latest",
-		"redis:latest",
-		"node:lts",
-		"python:3.9",
-		"golang:latest",
-		"mysql:8.0",
-		"mongo:latest",
-	}
-
-	// Filter out what we already have synced recently
-	// This makes it "smart" - it won't suggest what you already have
-	existing, err := p.db.GetRecentSyncs(100)
+	candidates, err := p.usageCandidates()
 	if err != nil {
 		return nil, err
 	}
 
+	mirrored, err := p.mirroredTags()
+	if err != nil {
+		return nil, err
+	}
+	candidates = missingFirst(candidates, limit, limit*checksPerSuggestion, func(img *PopularImage) bool {
+		return p.checkFreshness(img, mirrored)
+	})
+
+	sortByScore(candidates)
+
+	var suggestions []PopularImage
+	for _, img := range head(candidates, limit) {
+		suggestions = append(suggestions, *img)
+	}
+	return suggestions, nil
+}
+
+// usageCandidates scores every pulled image by decayed frequency and
+// recency, then appends the candidate images nobody pulled
+func (p *Predictor) usageCandidates() ([]*PopularImage, error) {
+	now := p.now()
+	pulls, err := p.db.GetPulls(now.Add(-pullWindow))
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := make(map[string]*PopularImage)
+	decayed := make(map[string]float64)
+	var candidates []*PopularImage
+
+	for _, pull := range pulls {
+		name := pull.Image + ":" + pull.Tag
+		key := imageKey(name)
+
+		img, ok := byKey[key]
+		if !ok {
+			img = &PopularImage{Name: name}
+			byKey[key] = img
+			candidates = append(candidates, img)
+		}
+
+		age := now.Sub(pull.Timestamp)
+		decayed[key] += p.decay(age)
+		if age <= recentWindow {
+			img.PullCount++
+		}
+		if pull.Timestamp.After(img.LastPull) {
+			img.LastPull = pull.Timestamp
+		}
+	}
+
+	for key, img := range byKey {
+		recency := p.decay(now.Sub(img.LastPull))
+		img.Score = decayed[key] * (1 + recency)
+	}
+
+	for _, c := range p.candidates {
+		key := imageKey(c.Name)
+		if img, ok := byKey[key]; ok {
+			if img.Source == "" {
+				img.Source = c.Source
+			}
+			continue
+		}
+		byKey[key] = &PopularImage{Name: c.Name, Source: c.Source}
+		candidates = append(candidates, byKey[key])
+	}
+
+	sortByScore(candidates)
+	return candidates, nil
+}
+
+// decay returns 0.5^(age/halfLife)
+func (p *Predictor) decay(age time.Duration) float64 {
+	if age < 0 {
+		age = 0
+	}
+	return math.Pow(0.5, float64(age)/float64(p.halfLife))
+}
+
+// checkFreshness compares a candidate with the local inventory, and a
+// mirrored tag older than tagMaxAge with the digest Docker Hub has for it. A
+// tag that moved is compared layer by layer. It reports whether Docker Hub
+// was contacted.
+func (p *Predictor) checkFreshness(img *PopularImage, mirrored map[string]*storage.TagRecord) bool {
+	key := imageKey(img.Name)
+	tag, ok := mirrored[key]
+	if !ok {
+		img.Checked = true
+		img.Score++
+		return false
+	}
+
+	img.Mirrored = true
+	if tag == nil || p.client == nil || p.now().Sub(tag.Updated) < tagMaxAge {
+		// Synced recently, or before the inventory recorded what: take it as current
+		img.Checked = true
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	// A HEAD doesn't count against Docker Hub's pull rate limit
+	digest, err := p.client.GetManifestDigest(ctx, img.Name)
+	if err != nil || digest == tag.Manifest.Digest {
+		// Unreachable upstream isn't a reason to sync again
+		img.Checked = true
+		return true
+	}
+
+	manifest, err := p.client.GetManifest(ctx, img.Name)
+	if err != nil {
+		return true // rank on usage alone
+	}
+	blobs, err := p.db.GetManifestBlobs(tag.Manifest.Digest)
+	if err != nil {
+		return true
+	}
 	have := make(map[string]bool)
-	for _, rec := range existing {
-		if rec.Status == "completed" {
-			have[rec.Image] = true
+	for _, b := range blobs {
+		have[b.Digest] = true
+	}
+
+	for _, l := range manifest.Layers {
+		img.TotalBytes += l.Size
+		if !have[l.Digest] {
+			img.MissingLayers++
+			img.MissingBytes += l.Size
 		}
 	}
+	img.Checked = true
+	if img.TotalBytes > 0 {
+		img.Score += float64(img.MissingBytes) / float64(img.TotalBytes)
+	}
+	return true
+}
 
-	var suggestions []PopularImage
-	for _, img := range commonImages {
-		if !have[img] {
-			suggestions = append(suggestions, PopularImage{Name: img, PullCount: 0})
+// mirroredTags returns the tags of the local inventory by image key. Images
+// in the sync history the inventory doesn't know map to nil.
+func (p *Predictor) mirroredTags() (map[string]*storage.TagRecord, error) {
+	synced, err := p.db.GetCachedImages()
+	if err != nil {
+		return nil, err
+	}
+	tags, err := p.db.GetTags("")
+	if err != nil {
+		return nil, err
+	}
+
+	mirrored := make(map[string]*storage.TagRecord)
+	for _, rec := range synced {
+		mirrored[imageKey(rec.Image)] = nil
+	}
+	for i := range tags {
+		mirrored[imageKey(tags[i].Ref())] = &tags[i]
+	}
+	return mirrored, nil
+}
+
+// missingFirst checks candidates in order until limit of them are missing
+// from the local registry, or check has contacted Docker Hub max times.
+// Candidates that couldn't be compared are kept.
+func missingFirst(candidates []*PopularImage, limit, max int, check func(*PopularImage) bool) []*PopularImage {
+	var kept []*PopularImage
+	var requests int
+	for _, img := range candidates {
+		if len(kept) >= limit || requests >= max {
+			break
+		}
+		if check(img) {
+			requests++
+		}
+		if !img.Checked || !img.Mirrored || img.MissingLayers > 0 {
+			kept = append(kept, img)
 		}
 	}
+	return kept
+}
+
+// sortByScore orders by score, keeping the original order for ties
+func sortByScore(images []*PopularImage) {
+	sort.SliceStable(images, func(i, j int) bool { return images[i].Score > images[j].Score })
+}
 
-	if len(suggestions) > limit {
-		suggestions = suggestions[:limit]
+func head(images []*PopularImage, n int) []*PopularImage {
+	if len(images) > n {
+		return images[:n]
 	}
+	return images
+}
 
-	return suggestions, nil
+// imageKey normalises an image reference so "nginx" and "library/nginx:latest" compare equal
+func imageKey(image string) string {
+	name, tag := registry.ParseReference(image)
+	return registry.UpstreamName(name) + ":" + tag
 }
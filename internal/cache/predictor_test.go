@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/storage"
+)
+
+func TestDecay(t *testing.T) {
+	p := NewPredictor(nil)
+	p.SetHalfLife(24 * time.Hour)
+
+	if got := p.decay(24 * time.Hour); math.Abs(got-0.5) > 1e-9 {
+		t.Errorf("Expected decay of 0.5 after one half-life, got %f", got)
+	}
+	if got := p.decay(0); got != 1 {
+		t.Errorf("Expected decay of 1 for a fresh pull, got %f", got)
+	}
+}
+
+func TestPredictRanksRecentPullsFirst(t *testing.T) {
+	db, err := storage.Open("memory://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	// Five old pulls of redis vs two fresh pulls of postgres
+	for i := 0; i < 5; i++ {
+		db.RecordPull(storage.PullRecord{Image: "library/redis", Tag: "7", Timestamp: now.Add(-20 * 24 * time.Hour)})
+	}
+	for i := 0; i < 2; i++ {
+		db.RecordPull(storage.PullRecord{Image: "library/postgres", Tag: "16", Timestamp: now.Add(-time.Hour)})
+	}
+
+	p := NewPredictor(db)
+	p.SetHalfLife(48 * time.Hour)
+
+	suggestions, err := p.PredictTopImages(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(suggestions) != 3 {
+		t.Fatalf("Expected 3 suggestions, got %d", len(suggestions))
+	}
+	if suggestions[0].Name != "library/postgres:16" || suggestions[1].Name != "library/redis:7" {
+		t.Errorf("Expected postgres then redis, got %s, %s", suggestions[0].Name, suggestions[1].Name)
+	}
+	if suggestions[0].PullCount != 2 || suggestions[1].PullCount != 0 {
+		t.Errorf("Unexpected 7-day pull counts %d, %d", suggestions[0].PullCount, suggestions[1].PullCount)
+	}
+	if got := suggestions[0].Explain(); got != "pulled 2× in the last 7 days, not mirrored yet" {
+		t.Errorf("Unexpected explanation %q", got)
+	}
+}
+
+func TestPredictChecksUntilEnoughImagesAreMissing(t *testing.T) {
+	// The five most popular images are already mirrored
+	var candidates []*PopularImage
+	for i := 0; i < 10; i++ {
+		candidates = append(candidates, &PopularImage{Name: fmt.Sprintf("image%d:latest", i), Score: float64(10 - i)})
+	}
+	var checked int
+	check := func(img *PopularImage) bool {
+		checked++
+		img.Checked, img.Mirrored = true, true
+		if img.Score <= 5 {
+			img.MissingLayers = 1
+		}
+		return true
+	}
+
+	got := missingFirst(candidates, 2, 10, check)
+	if len(got) != 2 || got[0].Name != "image5:latest" || got[1].Name != "image6:latest" {
+		t.Fatalf("Expected image5 and image6, got %+v", got)
+	}
+	if checked != 7 {
+		t.Errorf("Expected to stop checking after 7 images, checked %d", checked)
+	}
+
+	// The cap bounds the requests even when too few images are missing
+	for _, img := range candidates {
+		*img = PopularImage{Name: img.Name, Score: img.Score}
+	}
+	checked = 0
+	if got := missingFirst(candidates, 2, 4, check); len(got) != 0 || checked != 4 {
+		t.Errorf("Expected 4 checks and nothing missing, got %d checks and %+v", checked, got)
+	}
+}
+
+func TestPredictSkipsMirroredImagesWithoutAskingUpstream(t *testing.T) {
+	db, err := storage.Open("memory://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	for _, image := range []string{"library/redis", "library/postgres", "library/nginx"} {
+		db.RecordPull(storage.PullRecord{Image: image, Tag: "latest", Timestamp: now.Add(-time.Hour)})
+	}
+	db.RecordImage("redis", "latest", storage.ManifestRecord{Digest: "sha256:redis"}, []storage.BlobRef{{Digest: "sha256:layer", Size: 10}})
+	db.RecordSync(storage.SyncRecord{Image: "nginx:latest", Status: "completed", Duration: 1})
+
+	// Without UseRegistry nothing can reach Docker Hub
+	p := NewPredictor(db)
+	p.SetCandidates(nil)
+	suggestions, err := p.PredictTopImages(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(suggestions) != 1 || suggestions[0].Name != "library/postgres:latest" {
+		t.Fatalf("Expected only postgres, got %+v", suggestions)
+	}
+	if got := suggestions[0].Explain(); got != "pulled 1× in the last 7 days, not mirrored yet" {
+		t.Errorf("Unexpected explanation %q", got)
+	}
+}
@@ -0,0 +1,269 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/cache"
+	"github.com/spf13/viper"
+)
+
+// EnvPrefix is prepended to config keys to get their environment variable,
+// with dots replaced by underscores: cache.max_size_mb is REGISTRY_MIRROR_CACHE_MAX_SIZE_MB
+const EnvPrefix = "REGISTRY_MIRROR"
+
+// Config is the mirror's configuration. Each value comes from, in order of
+// precedence, a command-line flag, an environment variable, the config file
+// and the defaults.
+type Config struct {
+	// Profile is the profile merged over the top-level settings, see ApplyProfile
+	Profile string `mapstructure:"profile" yaml:"profile,omitempty"`
+
+	// Registry is the address of the local registry, host:port
+	Registry string `mapstructure:"registry" yaml:"registry"`
+	// DB is a SQLite file, a postgres:// URL or memory://; empty for ~/.registry-mirror.db
+	DB string `mapstructure:"db" yaml:"db"`
+	// Parallel is the number of layers transferred at once
+	Parallel int `mapstructure:"parallel" yaml:"parallel"`
+	// Upstream is the Docker Hub account to pull with
+	Upstream Upstream `mapstructure:"upstream" yaml:"upstream"`
+
+	JSON  bool `mapstructure:"json" yaml:"json"`
+	Plain bool `mapstructure:"plain" yaml:"plain"`
+
+	Cache     Cache     `mapstructure:"cache" yaml:"cache"`
+	Retention Retention `mapstructure:"retention" yaml:"retention"`
+	// Pinned images are never evicted
+	Pinned []string `mapstructure:"pinned" yaml:"pinned"`
+	Auto   Auto     `mapstructure:"auto" yaml:"auto"`
+	// Catalogs are curated image lists for 'auto --catalog'
+	Catalogs map[string][]string `mapstructure:"catalogs" yaml:"catalogs"`
+
+	Serve   Serve   `mapstructure:"serve" yaml:"serve"`
+	Webhook Webhook `mapstructure:"webhook" yaml:"webhook"`
+}
+
+// Upstream credentials, anonymous when Username is empty. Password may be an access token.
+type Upstream struct {
+	Username string `mapstructure:"username" yaml:"username"`
+	Password string `mapstructure:"password" yaml:"password"`
+}
+
+type Cache struct {
+	MaxSizeMB int64         `mapstructure:"max_size_mb" yaml:"max_size_mb"`
+	Policy    string        `mapstructure:"policy" yaml:"policy"`
+	TTL       time.Duration `mapstructure:"ttl" yaml:"ttl"` // with policy TTL
+	// StoragePath is the filesystem backing the registry, kept MinFreeMB free
+	StoragePath string `mapstructure:"storage_path" yaml:"storage_path"`
+	MinFreeMB   int64  `mapstructure:"min_free_mb" yaml:"min_free_mb"`
+	// GCContainer is the docker container of the registry, to run its garbage
+	// collector in after evicting. Without it eviction is never automatic.
+	GCContainer string `mapstructure:"gc_container" yaml:"gc_container"`
+}
+
+// Retention limits the sync history, 0 keeps everything
+type Retention struct {
+	KeepDays     int `mapstructure:"keep_days" yaml:"keep_days"`
+	KeepPerImage int `mapstructure:"keep_per_image" yaml:"keep_per_image"`
+}
+
+type Auto struct {
+	Top      int           `mapstructure:"top" yaml:"top"`
+	HalfLife time.Duration `mapstructure:"half_life" yaml:"half_life"`
+}
+
+type Serve struct {
+	Listen   string        `mapstructure:"listen" yaml:"listen"`
+	TagTTL   time.Duration `mapstructure:"tag_ttl" yaml:"tag_ttl"`
+	Prefetch bool          `mapstructure:"prefetch" yaml:"prefetch"`
+}
+
+type Webhook struct {
+	Listen   string `mapstructure:"listen" yaml:"listen"`
+	Path     string `mapstructure:"path" yaml:"path"`
+	Prefetch bool   `mapstructure:"prefetch" yaml:"prefetch"`
+}
+
+// Default returns the configuration used when nothing is set
+func Default() *Config {
+	return &Config{
+		Registry: "localhost:5000",
+		Parallel: 3,
+		Cache: Cache{
+			MaxSizeMB: 10000,
+			Policy:    string(cache.PolicyLRU),
+			TTL:       cache.DefaultTTL,
+		},
+		Pinned:   []string{},
+		Auto:     Auto{Top: 5, HalfLife: cache.DefaultHalfLife},
+		Catalogs: map[string][]string{},
+		Serve:    Serve{Listen: ":5000", TagTTL: time.Hour},
+		Webhook:  Webhook{Listen: ":5001", Path: "/events"},
+	}
+}
+
+// defaults maps every key to its default value
+func defaults() map[string]interface{} {
+	d := Default()
+	return map[string]interface{}{
+		"profile":                  d.Profile,
+		"registry":                 d.Registry,
+		"db":                       d.DB,
+		"parallel":                 d.Parallel,
+		"upstream.username":        d.Upstream.Username,
+		"upstream.password":        d.Upstream.Password,
+		"json":                     d.JSON,
+		"plain":                    d.Plain,
+		"cache.max_size_mb":        d.Cache.MaxSizeMB,
+		"cache.policy":             d.Cache.Policy,
+		"cache.ttl":                d.Cache.TTL,
+		"cache.storage_path":       d.Cache.StoragePath,
+		"cache.min_free_mb":        d.Cache.MinFreeMB,
+		"cache.gc_container":       d.Cache.GCContainer,
+		"retention.keep_days":      d.Retention.KeepDays,
+		"retention.keep_per_image": d.Retention.KeepPerImage,
+		"pinned":                   d.Pinned,
+		"auto.top":                 d.Auto.Top,
+		"auto.half_life":           d.Auto.HalfLife,
+		"catalogs":                 d.Catalogs,
+		"serve.listen":             d.Serve.Listen,
+		"serve.tag_ttl":            d.Serve.TagTTL,
+		"serve.prefetch":           d.Serve.Prefetch,
+		"webhook.listen":           d.Webhook.Listen,
+		"webhook.path":             d.Webhook.Path,
+		"webhook.prefetch":         d.Webhook.Prefetch,
+	}
+}
+
+// SetDefaults registers every key with its default, which also makes
+// environment variables apply to keys the config file doesn't set
+func SetDefaults(v *viper.Viper) {
+	for key, value := range defaults() {
+		v.SetDefault(key, value)
+	}
+}
+
+// Load decodes the merged configuration. Keys the config file sets that
+// aren't known, such as misspelt ones, are an error.
+func Load(v *viper.Viper) (*Config, error) {
+	// Profiles are checked as they're applied
+	if unknown := unknownKeys(v, ""); len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown keys: %s", strings.Join(unknown, ", "))
+	}
+
+	var c Config
+	if err := v.Unmarshal(&c); err != nil {
+		return nil, err
+	}
+	c.Cache.Policy = strings.ToUpper(c.Cache.Policy)
+	return &c, nil
+}
+
+// unknownKeys returns the keys under prefix that aren't settings, sorted.
+// Profiles and catalogs hold names of their own and are skipped.
+func unknownKeys(v *viper.Viper, prefix string) []string {
+	known := defaults()
+	var unknown []string
+	for _, key := range v.AllKeys() {
+		setting := strings.TrimPrefix(key, prefix)
+		if !strings.HasPrefix(key, prefix) || strings.HasPrefix(setting, "profiles.") || strings.HasPrefix(setting, "catalogs.") {
+			continue
+		}
+		if _, ok := known[setting]; !ok {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// ValidationError lists everything wrong with a configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+// Validate checks that the values make sense together, returning a
+// *ValidationError if they don't
+func (c *Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	switch {
+	case c.Registry == "":
+		add("registry must be set")
+	case strings.Contains(c.Registry, "://") || strings.Contains(c.Registry, "/"):
+		add("registry %q must be a host:port address, without scheme or path", c.Registry)
+	}
+	if strings.HasPrefix(c.DB, "postgres://") || strings.HasPrefix(c.DB, "postgresql://") {
+		if u, err := url.Parse(c.DB); err != nil || u.Host == "" || strings.Trim(u.Path, "/") == "" {
+			add("db must be a postgres://[user[:password]@]host[:port]/database URL")
+		}
+	}
+	if c.Parallel < 1 || c.Parallel > 64 {
+		add("parallel must be between 1 and 64, not %d", c.Parallel)
+	}
+	if c.Upstream.Username != "" && c.Upstream.Password == "" {
+		add("upstream.username needs upstream.password")
+	}
+
+	if c.Cache.MaxSizeMB <= 0 {
+		add("cache.max_size_mb must be positive, not %d", c.Cache.MaxSizeMB)
+	}
+	if _, err := cache.NewPolicy(cache.PolicyType(c.Cache.Policy), c.Cache.TTL); err != nil {
+		add("cache.policy: %v", err)
+	}
+	if c.Cache.TTL < 0 {
+		add("cache.ttl must not be negative")
+	}
+	if c.Cache.MinFreeMB < 0 {
+		add("cache.min_free_mb must not be negative")
+	}
+	if c.Cache.MinFreeMB > 0 && c.Cache.StoragePath == "" {
+		add("cache.min_free_mb needs cache.storage_path")
+	}
+
+	if c.Retention.KeepDays < 0 {
+		add("retention.keep_days must not be negative")
+	}
+	if c.Retention.KeepPerImage < 0 {
+		add("retention.keep_per_image must not be negative")
+	}
+
+	if c.Auto.Top < 1 {
+		add("auto.top must be at least 1, not %d", c.Auto.Top)
+	}
+	if c.Auto.HalfLife <= 0 {
+		add("auto.half_life must be positive")
+	}
+	for name, images := range c.Catalogs {
+		if len(images) == 0 {
+			add("catalog %q is empty", name)
+		}
+	}
+	for _, img := range c.Pinned {
+		if strings.TrimSpace(img) == "" {
+			add("pinned contains an empty image name")
+		}
+	}
+
+	if c.Serve.TagTTL <= 0 {
+		add("serve.tag_ttl must be positive")
+	}
+	if !strings.HasPrefix(c.Webhook.Path, "/") {
+		add("webhook.path %q must start with /", c.Webhook.Path)
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
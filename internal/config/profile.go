@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// A config file can hold named profiles under profiles:, each overriding some
+// of the top-level settings:
+//
+//	registry: localhost:5000
+//	profiles:
+//	  office:
+//	    registry: registry.corp:5000
+//	    upstream:
+//	      username: ci
+//
+// Every profile keeps its own sync history: unless it sets db, its database
+// is the top-level one with the profile name appended. A database given with
+// --db or REGISTRY_MIRROR_DB is used as it is.
+
+// Profiles returns the names of the profiles in the config file
+func Profiles(v *viper.Viper) []string {
+	var names []string
+	for name := range v.GetStringMap("profiles") {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ApplyProfile merges a profile over the top-level settings of the config
+// file. Environment variables and flags still take precedence over it.
+func ApplyProfile(v *viper.Viper, name string) error {
+	if name == "" {
+		return nil
+	}
+	// Viper lowercases keys
+	name = strings.ToLower(name)
+
+	key := "profiles." + name
+	if !v.IsSet(key) {
+		if names := Profiles(v); len(names) > 0 {
+			return fmt.Errorf("unknown profile %q (available: %s)", name, strings.Join(names, ", "))
+		}
+		return fmt.Errorf("unknown profile %q: the config file defines no profiles", name)
+	}
+	if unknown := unknownKeys(v, key+"."); len(unknown) > 0 {
+		return fmt.Errorf("unknown keys: %s", strings.Join(unknown, ", "))
+	}
+
+	settings := v.GetStringMap(key)
+	_, dbFromEnv := os.LookupEnv(EnvPrefix + "_DB")
+	if _, ok := settings["db"]; !ok && !dbFromEnv {
+		db, err := ProfileDB(v.GetString("db"), name)
+		if err != nil {
+			return err
+		}
+		settings["db"] = db
+	}
+	return v.MergeConfigMap(settings)
+}
+
+// ProfileDB derives a profile's database from the shared one, so histories
+// don't mix: ~/.registry-mirror.db becomes ~/.registry-mirror-<profile>.db.
+// A PostgreSQL database can't be derived and has to be set in the profile.
+func ProfileDB(db, profile string) (string, error) {
+	switch {
+	case db == "":
+		return "~/.registry-mirror-" + profile + ".db", nil
+	case strings.HasPrefix(db, "postgres://"), strings.HasPrefix(db, "postgresql://"):
+		return "", fmt.Errorf("profile %q would share the PostgreSQL database, set profiles.%s.db", profile, profile)
+	case strings.HasPrefix(db, "memory://"), db == ":memory:":
+		return db, nil
+	}
+
+	prefix := ""
+	if strings.HasPrefix(db, "sqlite://") {
+		prefix, db = "sqlite://", strings.TrimPrefix(db, "sqlite://")
+	}
+	ext := filepath.Ext(db)
+	return prefix + strings.TrimSuffix(db, ext) + "-" + profile + ext, nil
+}
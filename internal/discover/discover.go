@@ -0,0 +1,154 @@
+package discover
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/registry"
+)
+
+// Image is an image reference found in a project, with every place it was seen
+type Image struct {
+	Ref     string   `json:"ref"`
+	Sources []string `json:"sources"`
+}
+
+// skipDirs are never worth descending into
+var skipDirs = map[string]bool{
+	".git":         true,
+	"node_modules": true,
+	"vendor":       true,
+	".terraform":   true,
+}
+
+// Scan walks root and returns the deduplicated, sorted image references
+// found in Dockerfiles, compose files, Kubernetes manifests, Helm values
+// and GitHub Actions workflows
+func Scan(root string) ([]Image, error) {
+	found := make(map[string]*Image)
+
+	add := func(ref, source string) {
+		ref = normalize(ref)
+		if ref == "" {
+			return
+		}
+		img, ok := found[ref]
+		if !ok {
+			img = &Image{Ref: ref}
+			found[ref] = img
+		}
+		img.Sources = append(img.Sources, source)
+	}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && skipDirs[d.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		kind := classify(path)
+		if kind == kindNone {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(root, path)
+		var refs []string
+		switch kind {
+		case kindDockerfile:
+			refs = parseDockerfile(string(data))
+		case kindCompose:
+			refs = parseCompose(data)
+		case kindWorkflow:
+			refs = parseWorkflow(data)
+		case kindHelmValues:
+			refs = parseHelmValues(data)
+		case kindYAML:
+			refs = parseKubernetes(data)
+		}
+
+		for _, ref := range refs {
+			add(ref, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	images := make([]Image, 0, len(found))
+	for _, img := range found {
+		images = append(images, *img)
+	}
+	sort.Slice(images, func(i, j int) bool { return images[i].Ref < images[j].Ref })
+	return images, nil
+}
+
+// Refs returns just the references from a scan result
+func Refs(images []Image) []string {
+	refs := make([]string, len(images))
+	for i, img := range images {
+		refs[i] = img.Ref
+	}
+	return refs
+}
+
+type fileKind int
+
+const (
+	kindNone fileKind = iota
+	kindDockerfile
+	kindCompose
+	kindWorkflow
+	kindHelmValues
+	kindYAML
+)
+
+func classify(path string) fileKind {
+	base := strings.ToLower(filepath.Base(path))
+	slashed := filepath.ToSlash(path)
+
+	switch {
+	case base == "dockerfile" || base == "containerfile" ||
+		strings.HasPrefix(base, "dockerfile.") || strings.HasSuffix(base, ".dockerfile"):
+		return kindDockerfile
+	case !strings.HasSuffix(base, ".yml") && !strings.HasSuffix(base, ".yaml"):
+		return kindNone
+	case strings.Contains(slashed, ".github/workflows/"):
+		return kindWorkflow
+	case strings.HasPrefix(base, "docker-compose") || strings.HasPrefix(base, "compose"):
+		return kindCompose
+	case strings.HasPrefix(base, "values"):
+		return kindHelmValues
+	default:
+		return kindYAML
+	}
+}
+
+// normalize turns a discovered string into a clean image reference, or ""
+// if it still contains unresolved variables or templates
+func normalize(ref string) string {
+	ref = strings.TrimSpace(ref)
+	ref = strings.Trim(ref, `"'`)
+	if ref == "" || ref == "scratch" || strings.ContainsAny(ref, "${}") || strings.ContainsAny(ref, " \t") {
+		return ""
+	}
+
+	name, tag := registry.ParseReference(ref)
+	if strings.HasPrefix(tag, "sha256:") {
+		return name + "@" + tag
+	}
+	return name + ":" + tag
+}
@@ -0,0 +1,108 @@
+package discover
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseDockerfileMultiStage(t *testing.T) {
+	content := `# syntax=docker/dockerfile:1
+ARG GO_VERSION=1.22
+ARG BASE=distroless/static
+ARG REGISTRY
+
+FROM --platform=$BUILDPLATFORM golang:${GO_VERSION}-alpine AS build
+RUN go build ./...
+
+FROM build AS test
+RUN go test ./...
+
+FROM gcr.io/${BASE}:nonroot
+FROM ${REGISTRY}/internal/tool:1
+FROM ${MISSING:-alpine}:3.19
+FROM scratch
+`
+	got := parseDockerfile(content)
+	want := []string{
+		"golang:1.22-alpine",
+		"gcr.io/distroless/static:nonroot",
+		"${REGISTRY}/internal/tool:1",
+		"alpine:3.19",
+		"scratch",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDockerfile() = %v, want %v", got, want)
+	}
+}
+
+func TestScan(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"Dockerfile": "FROM node:20\n",
+		"docker-compose.yml": `services:
+  db:
+    image: postgres:16
+  cache:
+    image: redis
+  app:
+    build: .
+`,
+		"k8s/deploy.yaml": `apiVersion: apps/v1
+kind: Deployment
+spec:
+  template:
+    spec:
+      initContainers:
+        - name: migrate
+          image: flyway/flyway:10
+      containers:
+        - name: app
+          image: node:20
+`,
+		"chart/values.yaml": `image:
+  repository: bitnami/nginx
+  tag: "1.25"
+`,
+		".github/workflows/ci.yml": `jobs:
+  test:
+    container: golang:1.22
+    services:
+      postgres:
+        image: postgres:16
+    steps:
+      - uses: docker://hadolint/hadolint:latest
+`,
+		"node_modules/pkg/Dockerfile": "FROM ignored:1\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(content), 0644)
+	}
+
+	images, err := Scan(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"bitnami/nginx:1.25",
+		"flyway/flyway:10",
+		"golang:1.22",
+		"hadolint/hadolint:latest",
+		"node:20",
+		"postgres:16",
+		"redis:latest",
+	}
+	if got := Refs(images); !reflect.DeepEqual(got, want) {
+		t.Errorf("Scan() = %v, want %v", got, want)
+	}
+
+	for _, img := range images {
+		if img.Ref == "postgres:16" && len(img.Sources) != 2 {
+			t.Errorf("Expected postgres:16 from two files, got %v", img.Sources)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package discover
+
+import (
+	"regexp"
+	"strings"
+)
+
+var argRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:?-([^}]*))?\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+
+// parseDockerfile returns the images named in FROM instructions. ARGs
+// declared before the first FROM are substituted using their defaults, and
+// FROMs that refer to an earlier build stage are skipped.
+func parseDockerfile(content string) []string {
+	args := make(map[string]string)
+	stages := make(map[string]bool)
+	seenFrom := false
+
+	var refs []string
+	for _, line := range logicalLines(content) {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "ARG":
+			// Only global ARGs (before the first FROM) can be used in FROM
+			if seenFrom {
+				continue
+			}
+			for _, decl := range fields[1:] {
+				name, value, hasValue := strings.Cut(decl, "=")
+				if hasValue {
+					args[name] = strings.Trim(value, `"'`)
+				}
+			}
+
+		case "FROM":
+			seenFrom = true
+
+			var tokens []string
+			for _, f := range fields[1:] {
+				if !strings.HasPrefix(f, "--") {
+					tokens = append(tokens, f)
+				}
+			}
+			if len(tokens) == 0 {
+				continue
+			}
+
+			image := expandArgs(tokens[0], args)
+			if !stages[strings.ToLower(image)] {
+				refs = append(refs, image)
+			}
+
+			// Register the stage so later FROMs can build on it
+			if len(tokens) >= 3 && strings.EqualFold(tokens[1], "AS") {
+				stages[strings.ToLower(tokens[2])] = true
+			}
+		}
+	}
+	return refs
+}
+
+// logicalLines joins backslash continuations and drops comments
+func logicalLines(content string) []string {
+	var lines []string
+	var current strings.Builder
+
+	for _, raw := range strings.Split(content, "\n") {
+		line := strings.TrimSpace(raw)
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasSuffix(line, "\\") {
+			current.WriteString(strings.TrimSuffix(line, "\\"))
+			current.WriteString(" ")
+			continue
+		}
+		current.WriteString(line)
+		lines = append(lines, current.String())
+		current.Reset()
+	}
+	if current.Len() > 0 {
+		lines = append(lines, current.String())
+	}
+	return lines
+}
+
+// expandArgs substitutes $VAR, ${VAR} and ${VAR:-default}. Unknown variables
+// without a default are left in place so the reference gets discarded.
+func expandArgs(s string, args map[string]string) string {
+	return argRef.ReplaceAllStringFunc(s, func(m string) string {
+		sub := argRef.FindStringSubmatch(m)
+		name := sub[1]
+		if name == "" {
+			name = sub[4]
+		}
+
+		value, ok := args[name]
+		if ok && (value != "" || sub[2] == "-") {
+			return value
+		}
+		if sub[2] != "" {
+			return sub[3]
+		}
+		return m
+	})
+}
@@ -0,0 +1,163 @@
+package discover
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// decodeAll parses every document of a (possibly multi-document) YAML file.
+// Files that aren't valid YAML (e.g. Helm templates) yield no documents.
+func decodeAll(data []byte) []interface{} {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+
+	var docs []interface{}
+	for {
+		var doc interface{}
+		if err := dec.Decode(&doc); err != nil {
+			// io.EOF at the end, or a parse error we can't recover from
+			return docs
+		}
+		if doc != nil {
+			docs = append(docs, doc)
+		}
+	}
+}
+
+// parseCompose returns services.*.image from a docker-compose file
+func parseCompose(data []byte) []string {
+	var refs []string
+	for _, doc := range decodeAll(data) {
+		services, _ := asMap(doc)["services"].(map[string]interface{})
+		for _, svc := range services {
+			if image, ok := asMap(svc)["image"].(string); ok {
+				refs = append(refs, image)
+			}
+		}
+	}
+	return refs
+}
+
+// parseWorkflow returns the job containers, service containers and
+// `uses: docker://` steps of a GitHub Actions workflow
+func parseWorkflow(data []byte) []string {
+	var refs []string
+	for _, doc := range decodeAll(data) {
+		jobs, _ := asMap(doc)["jobs"].(map[string]interface{})
+		for _, j := range jobs {
+			job := asMap(j)
+
+			if image := containerImage(job["container"]); image != "" {
+				refs = append(refs, image)
+			}
+
+			services, _ := job["services"].(map[string]interface{})
+			for _, svc := range services {
+				if image := containerImage(svc); image != "" {
+					refs = append(refs, image)
+				}
+			}
+
+			steps, _ := job["steps"].([]interface{})
+			for _, step := range steps {
+				if uses, ok := asMap(step)["uses"].(string); ok && strings.HasPrefix(uses, "docker://") {
+					refs = append(refs, strings.TrimPrefix(uses, "docker://"))
+				}
+			}
+		}
+	}
+	return refs
+}
+
+// containerImage reads a workflow container, which is either an image
+// string or a map with an image key
+func containerImage(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	image, _ := asMap(v)["image"].(string)
+	return image
+}
+
+// parseKubernetes returns container images from any manifest with a kind,
+// wherever its pod spec is nested (Deployment, CronJob, ...)
+func parseKubernetes(data []byte) []string {
+	var refs []string
+	for _, doc := range decodeAll(data) {
+		m := asMap(doc)
+		if _, ok := m["kind"]; !ok {
+			continue
+		}
+		walk(m, func(key string, value interface{}) {
+			switch key {
+			case "containers", "initContainers", "ephemeralContainers":
+				list, _ := value.([]interface{})
+				for _, c := range list {
+					if image, ok := asMap(c)["image"].(string); ok {
+						refs = append(refs, image)
+					}
+				}
+			}
+		})
+	}
+	return refs
+}
+
+// parseHelmValues returns images from Helm values files, which use either
+// `image: repo:tag` or the `image: {registry, repository, tag}` convention
+func parseHelmValues(data []byte) []string {
+	var refs []string
+	for _, doc := range decodeAll(data) {
+		walk(doc, func(key string, value interface{}) {
+			if key != "image" {
+				return
+			}
+			if s, ok := value.(string); ok {
+				refs = append(refs, s)
+				return
+			}
+
+			m := asMap(value)
+			repo, _ := m["repository"].(string)
+			if repo == "" {
+				return
+			}
+			if reg, _ := m["registry"].(string); reg != "" {
+				repo = reg + "/" + repo
+			}
+			switch tag := m["tag"].(type) {
+			case string:
+				if tag != "" {
+					repo += ":" + tag
+				}
+			case int, float64:
+				// Unquoted numeric tags such as `tag: 16`
+				repo += ":" + fmt.Sprint(tag)
+			}
+			refs = append(refs, repo)
+		})
+	}
+	return refs
+}
+
+// walk calls fn for every key/value pair in a decoded YAML tree
+func walk(v interface{}, fn func(key string, value interface{})) {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		for k, child := range node {
+			fn(k, child)
+			walk(child, fn)
+		}
+	case []interface{}:
+		for _, child := range node {
+			walk(child, fn)
+		}
+	}
+}
+
+func asMap(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	return m
+}
@@ -0,0 +1,23 @@
+// Package filelock serializes processes that share a file, such as the
+// database or the blob store's reference list
+package filelock
+
+import "os"
+
+// Lock blocks until it holds an exclusive lock on path, creating the file if
+// needed, and returns a function releasing it. Locks are advisory: they only
+// keep out other processes that take the same lock.
+func Lock(path string) (unlock func() error, err error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := flock(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() error {
+		funlock(f)
+		return f.Close()
+	}, nil
+}
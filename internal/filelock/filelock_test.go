@@ -0,0 +1,43 @@
+//go:build linux || darwin
+
+package filelock
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockExcludes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "x.lock")
+
+	unlock, err := Lock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// flock locks belong to the open file, so a second Lock waits like another process would
+	acquired := make(chan struct{})
+	go func() {
+		u, err := Lock(path)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		close(acquired)
+		u()
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("Expected the second lock to wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the second lock once the first was released")
+	}
+}
@@ -0,0 +1,89 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/blobstore"
+	"github.com/saurabh12nxf/registry-mirror/internal/registry"
+)
+
+// ErrorCategory is the broad cause of a failed sync
+type ErrorCategory string
+
+const (
+	CategoryAuth           ErrorCategory = "auth"
+	CategoryNotFound       ErrorCategory = "not-found"
+	CategoryRateLimited    ErrorCategory = "rate-limited"
+	CategoryNetwork        ErrorCategory = "network"
+	CategoryDigestMismatch ErrorCategory = "digest-mismatch"
+	CategoryPushRejected   ErrorCategory = "push-rejected"
+	CategoryUnknown        ErrorCategory = "unknown"
+)
+
+// Categories lists every error category, for validating filters
+var Categories = []ErrorCategory{
+	CategoryAuth, CategoryNotFound, CategoryRateLimited, CategoryNetwork,
+	CategoryDigestMismatch, CategoryPushRejected, CategoryUnknown,
+}
+
+// Classify returns the category of a sync error, "" for nil
+func Classify(err error) ErrorCategory {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, blobstore.ErrDigestMismatch) {
+		return CategoryDigestMismatch
+	}
+
+	var statusErr *registry.StatusError
+	if errors.As(err, &statusErr) {
+		return classifyStatus(statusErr)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.DeadlineExceeded) {
+		return CategoryNetwork
+	}
+	return CategoryUnknown
+}
+
+// failedForGood reports whether retrying a failed sync can't help, because
+// the image doesn't exist or its content doesn't match its digests
+func failedForGood(err error) bool {
+	switch Classify(err) {
+	case CategoryNotFound, CategoryDigestMismatch:
+		return true
+	}
+	return false
+}
+
+func classifyStatus(err *registry.StatusError) ErrorCategory {
+	if strings.Contains(err.Body, "DIGEST_INVALID") {
+		return CategoryDigestMismatch
+	}
+
+	switch err.Code {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return CategoryAuth
+	case http.StatusTooManyRequests:
+		return CategoryRateLimited
+	}
+
+	// Anything else the local registry refuses is a rejected push
+	if strings.HasPrefix(err.Op, "push") || strings.HasPrefix(err.Op, "start upload") {
+		return CategoryPushRejected
+	}
+
+	switch {
+	case err.Code == http.StatusNotFound:
+		return CategoryNotFound
+	case err.Code >= 500:
+		return CategoryNetwork
+	}
+	return CategoryUnknown
+}
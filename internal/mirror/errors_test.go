@@ -0,0 +1,33 @@
+package mirror
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/blobstore"
+	"github.com/saurabh12nxf/registry-mirror/internal/registry"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err  error
+		want ErrorCategory
+	}{
+		{nil, ""},
+		{fmt.Errorf("failed to get manifest: %w", &registry.StatusError{Op: "get manifest", Code: 401}), CategoryAuth},
+		{&registry.StatusError{Op: "get manifest", Code: 404}, CategoryNotFound},
+		{&registry.StatusError{Op: "pull layer", Code: 429}, CategoryRateLimited},
+		{&registry.StatusError{Op: "push manifest nginx:latest", Code: 400, Body: `{"errors":[{"code":"MANIFEST_INVALID"}]}`}, CategoryPushRejected},
+		{&registry.StatusError{Op: "push layer sha256:abc", Code: 400, Body: `{"errors":[{"code":"DIGEST_INVALID"}]}`}, CategoryDigestMismatch},
+		{fmt.Errorf("failed to cache layer: %w", blobstore.ErrDigestMismatch), CategoryDigestMismatch},
+		{&net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}, CategoryNetwork},
+		{fmt.Errorf("something else"), CategoryUnknown},
+	}
+
+	for _, tt := range tests {
+		if got := Classify(tt.err); got != tt.want {
+			t.Errorf("Classify(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
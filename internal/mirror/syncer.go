@@ -2,20 +2,35 @@ package mirror
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"os"
 	"sync"
 	"time"
 
+	"github.com/saurabh12nxf/registry-mirror/internal/blobstore"
 	"github.com/saurabh12nxf/registry-mirror/internal/registry"
+	"github.com/saurabh12nxf/registry-mirror/internal/storage"
 )
 
 type Syncer struct {
 	localRegistry string
 	parallelism   int
 	client        *registry.Client
+	store         *blobstore.Store
+	db            storage.DB
+	preflight     func(image string, blobs []storage.BlobRef) error
+	layerHook     func(image, digest string, size int64, status string)
 }
 
+// Layer statuses reported to the layer hook
+const (
+	LayerDone    = "done"
+	LayerSkipped = "skipped"
+	LayerFailed  = "failed"
+)
+
 type SyncProgress struct {
 	Image        string
 	TotalLayers  int
@@ -23,6 +38,39 @@ type SyncProgress struct {
 	BytesTotal   int64
 	BytesSynced  int64
 	StartTime    time.Time
+
+	mu            sync.Mutex
+	SkippedLayers int
+	BytesSkipped  int64
+}
+
+// SyncResult is what a sync did, as far as it got
+type SyncResult struct {
+	Image string
+	// UpstreamDigest is the manifest digest Docker Hub (or the blob store)
+	// served, LocalDigest the one the local registry stored it under
+	UpstreamDigest   string
+	LocalDigest      string
+	Layers           int
+	LayersSkipped    int
+	BytesTransferred int64
+	BytesSkipped     int64
+	Duration         time.Duration
+}
+
+// Record converts the result into a sync record with the given status
+func (r *SyncResult) Record(status string) storage.SyncRecord {
+	return storage.SyncRecord{
+		Image:          r.Image,
+		Status:         status,
+		Bytes:          r.BytesTransferred,
+		Duration:       r.Duration.Seconds(),
+		BytesSkipped:   r.BytesSkipped,
+		Layers:         r.Layers,
+		LayersSkipped:  r.LayersSkipped,
+		UpstreamDigest: r.UpstreamDigest,
+		LocalDigest:    r.LocalDigest,
+	}
 }
 
 func NewSyncer(localRegistry string, parallelism int) *Syncer {
@@ -33,17 +81,131 @@ func NewSyncer(localRegistry string, parallelism int) *Syncer {
 	}
 }
 
-func (s *Syncer) Sync(image string, force bool) error {
-	ctx := context.Background()
+// Client returns the registry client the syncer pushes with
+func (s *Syncer) Client() *registry.Client {
+	return s.client
+}
+
+// UseBlobStore makes the syncer stage every blob in the local blob store, so
+// content survives failed pushes and is only downloaded once
+func (s *Syncer) UseBlobStore(store *blobstore.Store) {
+	s.store = store
+}
+
+// UseDB makes the syncer record which blobs every synced image references,
+// so cache usage and eviction can account for shared layers
+func (s *Syncer) UseDB(db storage.DB) {
+	s.db = db
+}
+
+// SetPreflight registers a check that runs once the manifest is known and
+// before any layer is transferred; an error aborts the sync
+func (s *Syncer) SetPreflight(fn func(image string, blobs []storage.BlobRef) error) {
+	s.preflight = fn
+}
+
+// SetLayerHook registers a function called as every layer is transferred,
+// skipped or fails, e.g. to persist progress
+func (s *Syncer) SetLayerHook(fn func(image, digest string, size int64, status string)) {
+	s.layerHook = fn
+}
+
+func (s *Syncer) reportLayer(image string, layer registry.Layer, status string) {
+	if s.layerHook != nil {
+		s.layerHook(image, layer.Digest, layer.Size, status)
+	}
+}
+
+// Sync copies an image from Docker Hub to the local registry. Layers the
+// local registry already has are skipped unless force is set. The result is
+// never nil, and tells how far a failed sync got. Cancelling ctx stops the
+// transfers in flight.
+func (s *Syncer) Sync(ctx context.Context, image string, force bool) (*SyncResult, error) {
+	result := &SyncResult{Image: image}
+	start := time.Now()
+	defer func() { result.Duration = time.Since(start) }()
 
 	// Get manifest from Docker Hub
-	manifest, err := s.client.GetManifest(ctx, image)
+	raw, err := s.client.GetRawManifest(ctx, image)
+	if err != nil {
+		return result, fmt.Errorf("failed to get manifest: %w", err)
+	}
+
+	if s.store != nil {
+		if err := s.store.PutBytes(raw.Digest, raw.Data); err != nil {
+			return result, fmt.Errorf("failed to cache manifest: %w", err)
+		}
+	}
+
+	return result, s.syncManifest(ctx, image, raw, force, s.openLayer, result)
+}
+
+// SyncFromCache re-pushes an image using only the blob store, without
+// contacting Docker Hub. The image needs to have been staged by a Sync, which
+// may have failed to push; a blob missing from the store fails the sync.
+func (s *Syncer) SyncFromCache(ctx context.Context, image string) (*SyncResult, error) {
+	result := &SyncResult{Image: image}
+	start := time.Now()
+	defer func() { result.Duration = time.Since(start) }()
+
+	if s.store == nil {
+		return result, fmt.Errorf("no blob store configured")
+	}
+
+	refs, ok := s.store.Image(image)
+	if !ok {
+		return result, fmt.Errorf("%s is not in the blob cache", image)
+	}
+	data, err := s.store.ReadBytes(refs.Manifest)
+	if err != nil {
+		return result, fmt.Errorf("cached manifest for %s is missing: %w", image, err)
+	}
+
+	raw := &registry.RawManifest{Digest: refs.Manifest, MediaType: refs.MediaType, Data: data}
+	return result, s.syncManifest(ctx, image, raw, false, s.openCached, result)
+}
+
+// layerOpener returns the content of a layer to push
+type layerOpener func(ctx context.Context, image string, layer registry.Layer) (io.ReadCloser, error)
+
+func (s *Syncer) syncManifest(ctx context.Context, image string, raw *registry.RawManifest, force bool, open layerOpener, result *SyncResult) (err error) {
+	result.UpstreamDigest = raw.Digest
+
+	manifest, err := raw.Parse()
 	if err != nil {
-		return fmt.Errorf("failed to get manifest: %w", err)
+		return err
 	}
+	result.Layers = len(manifest.Layers)
 
 	fmt.Printf("📦 Found %d layers to sync\n", len(manifest.Layers))
 
+	refs := []storage.BlobRef{{Digest: manifest.Config.Digest, Size: manifest.Config.Size, MediaType: manifest.Config.MediaType}}
+	for _, l := range manifest.Layers {
+		refs = append(refs, storage.BlobRef{Digest: l.Digest, Size: l.Size, MediaType: l.MediaType})
+	}
+	if s.preflight != nil {
+		if err := s.preflight(image, refs); err != nil {
+			return err
+		}
+	}
+
+	// Referencing the blobs before they're staged keeps a blob store GC from
+	// removing them mid-sync, and keeps them for a retry if the push fails
+	cached := blobstore.ImageRefs{Manifest: raw.Digest, MediaType: raw.MediaType, Blobs: []string{manifest.Config.Digest}}
+	for _, l := range manifest.Layers {
+		cached.Blobs = append(cached.Blobs, l.Digest)
+	}
+	if s.store != nil {
+		if err := s.store.Stage(image, cached); err != nil {
+			return fmt.Errorf("failed to record cache references: %w", err)
+		}
+		defer func() {
+			if failedForGood(err) {
+				s.store.Unstage(image)
+			}
+		}()
+	}
+
 	progress := &SyncProgress{
 		Image:       image,
 		TotalLayers: len(manifest.Layers),
@@ -55,19 +217,56 @@ func (s *Syncer) Sync(image string, force bool) error {
 	}
 
 	// Sync layers in parallel
-	if err := s.syncLayers(ctx, image, manifest.Layers, progress); err != nil {
+	err = s.syncLayers(ctx, image, manifest.Layers, force, open, progress)
+	result.BytesTransferred, result.BytesSkipped = progress.BytesSynced, progress.BytesSkipped
+	result.LayersSkipped = progress.SkippedLayers
+	if err != nil {
+		return err
+	}
+
+	// The config blob and manifest make the layers pullable as a tagged image
+	if err := s.syncLayer(ctx, image, manifest.Config, open, 0, progress.TotalLayers); err != nil {
+		return fmt.Errorf("failed to sync config: %w", err)
+	}
+	name, tag := registry.ParseReference(image)
+	localDigest, err := s.client.PushManifest(ctx, name, tag, raw)
+	if err != nil {
 		return err
 	}
+	if localDigest == "" {
+		localDigest = raw.Digest
+	}
+	result.LocalDigest = localDigest
+	if localDigest != raw.Digest {
+		return fmt.Errorf("%w: manifest %s was stored as %s", blobstore.ErrDigestMismatch, raw.Digest, localDigest)
+	}
+
+	if s.store != nil {
+		if err := s.store.SetImage(image, cached); err != nil {
+			return fmt.Errorf("failed to record cache references: %w", err)
+		}
+	}
+
+	if s.db != nil {
+		record := storage.ManifestRecord{Digest: raw.Digest, MediaType: raw.MediaType, Size: int64(len(raw.Data))}
+		if p := s.platformOf(manifest.Config.Digest); p != nil {
+			record.OS, record.Architecture, record.Variant = p.OS, p.Architecture, p.Variant
+		}
+		if err := s.db.RecordImage(name, tag, record, refs); err != nil {
+			return fmt.Errorf("failed to record image references: %w", err)
+		}
+	}
 
 	elapsed := time.Since(progress.StartTime)
-	fmt.Printf("⏱️  Completed in %s (%.2f MB synced)\n",
+	fmt.Printf("⏱️  Completed in %s (%.2f MB synced, %.2f MB already present)\n",
 		elapsed.Round(time.Second),
-		float64(progress.BytesSynced)/(1024*1024))
+		float64(progress.BytesSynced)/(1024*1024),
+		float64(progress.BytesSkipped)/(1024*1024))
 
 	return nil
 }
 
-func (s *Syncer) syncLayers(ctx context.Context, image string, layers []registry.Layer, progress *SyncProgress) error {
+func (s *Syncer) syncLayers(ctx context.Context, image string, layers []registry.Layer, force bool, open layerOpener, progress *SyncProgress) error {
 	var wg sync.WaitGroup
 	errChan := make(chan error, len(layers))
 	semaphore := make(chan struct{}, s.parallelism)
@@ -80,11 +279,25 @@ func (s *Syncer) syncLayers(ctx context.Context, image string, layers []registry
 			semaphore <- struct{}{}
 			defer func() { <-semaphore }()
 
-			if err := s.syncLayer(ctx, image, l, idx+1, progress.TotalLayers); err != nil {
+			if !force && s.hasLayer(ctx, image, l.Digest) {
+				fmt.Printf("  [%d/%d] Layer %s already present\n", idx+1, progress.TotalLayers, l.Digest[:12])
+				progress.mu.Lock()
+				progress.SkippedLayers++
+				progress.BytesSkipped += l.Size
+				progress.mu.Unlock()
+				s.reportLayer(image, l, LayerSkipped)
+				return
+			}
+
+			if err := s.syncLayer(ctx, image, l, open, idx+1, progress.TotalLayers); err != nil {
+				s.reportLayer(image, l, LayerFailed)
 				errChan <- err
 			} else {
+				s.reportLayer(image, l, LayerDone)
+				progress.mu.Lock()
 				progress.SyncedLayers++
 				progress.BytesSynced += l.Size
+				progress.mu.Unlock()
 			}
 		}(i, layer)
 	}
@@ -99,12 +312,12 @@ func (s *Syncer) syncLayers(ctx context.Context, image string, layers []registry
 	return nil
 }
 
-func (s *Syncer) syncLayer(ctx context.Context, image string, layer registry.Layer, current, total int) error {
+func (s *Syncer) syncLayer(ctx context.Context, image string, layer registry.Layer, open layerOpener, current, total int) error {
 	fmt.Printf("  [%d/%d] Syncing layer %s (%.2f MB)...\n",
 		current, total, layer.Digest[:12], float64(layer.Size)/(1024*1024))
 
-	// Pull from Docker Hub
-	data, err := s.client.PullLayer(ctx, image, layer.Digest)
+	// Pull from Docker Hub (or the blob store)
+	data, err := open(ctx, image, layer)
 	if err != nil {
 		return fmt.Errorf("failed to pull layer: %w", err)
 	}
@@ -118,8 +331,65 @@ func (s *Syncer) syncLayer(ctx context.Context, image string, layer registry.Lay
 	return nil
 }
 
+// hasLayer reports whether the local registry already holds a layer in the
+// image's repository. Errors count as missing, so the layer is pushed anyway.
+func (s *Syncer) hasLayer(ctx context.Context, image, digest string) bool {
+	name, _ := registry.ParseReference(image)
+	ok, err := s.client.BlobExists(ctx, name, digest)
+	return err == nil && ok
+}
+
+// openLayer returns the layer content, writing it through the blob store when
+// one is configured so the push reads from disk rather than from upstream
+func (s *Syncer) openLayer(ctx context.Context, image string, layer registry.Layer) (io.ReadCloser, error) {
+	if s.store == nil {
+		return s.client.PullLayer(ctx, image, layer.Digest)
+	}
+
+	if !s.store.Has(layer.Digest) {
+		data, err := s.client.PullLayer(ctx, image, layer.Digest)
+		if err != nil {
+			return nil, err
+		}
+		_, err = s.store.Put(layer.Digest, data)
+		data.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to cache layer: %w", err)
+		}
+	}
+
+	f, _, err := s.store.Get(layer.Digest)
+	return f, err
+}
+
+// platformOf reads the os/architecture from a config blob in the blob store.
+// It returns nil when there's no store or the config can't be read.
+func (s *Syncer) platformOf(configDigest string) *registry.Platform {
+	if s.store == nil || configDigest == "" {
+		return nil
+	}
+	data, err := s.store.ReadBytes(configDigest)
+	if err != nil {
+		return nil
+	}
+	var p registry.Platform
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil
+	}
+	return &p
+}
+
 // Helper to copy data and track progress
 func copyWithProgress(dst io.Writer, src io.Reader, size int64) (int64, error) {
 	// Simple copy for now, can add progress bar later
 	return io.Copy(dst, src)
 }
+
+// openCached returns the layer content from the blob store only
+func (s *Syncer) openCached(ctx context.Context, image string, layer registry.Layer) (io.ReadCloser, error) {
+	f, _, err := s.store.Get(layer.Digest)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("layer %s of %s is not in the blob cache", layer.Digest, image)
+	}
+	return f, err
+}
@@ -2,7 +2,14 @@ package mirror
 
 import (
 	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/blobstore"
 )
 
 // Mock client logic would go here in a real large test suite
@@ -39,3 +46,37 @@ func TestContextCancellation(t *testing.T) {
 		t.Error("Context should be canceled")
 	}
 }
+
+func TestSyncFromCacheUsesOnlyTheStore(t *testing.T) {
+	store, err := blobstore.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := func(s string) string { return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(s))) }
+
+	config, layer := digest("config"), digest("layer")
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json",`+
+		`"config":{"digest":%q,"size":6},"layers":[{"digest":%q,"size":5}]}`, config, layer)
+	store.PutBytes(digest(manifest), []byte(manifest))
+	store.PutBytes(config, []byte("config"))
+
+	// A sync staged the image and failed before downloading the layer
+	if err := store.Stage("redis:7", blobstore.ImageRefs{Manifest: digest(manifest), Blobs: []string{config, layer}}); err != nil {
+		t.Fatal(err)
+	}
+
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer local.Close()
+
+	syncer := NewSyncer(strings.TrimPrefix(local.URL, "http://"), 1)
+	syncer.UseBlobStore(store)
+	_, err = syncer.SyncFromCache(context.Background(), "redis:7")
+	if err == nil || !strings.Contains(err.Error(), "not in the blob cache") {
+		t.Fatalf("Expected the missing layer to fail the sync, got %v", err)
+	}
+	if refs, ok := store.Image("redis:7"); !ok || !refs.Pending {
+		t.Errorf("Expected the staged references to be kept for a retry, got %+v", refs)
+	}
+}
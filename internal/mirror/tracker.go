@@ -1,34 +1,178 @@
 package mirror
 
 import (
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/saurabh12nxf/registry-mirror/internal/storage"
 )
 
+const (
+	// HeartbeatInterval is how often a running sync tells other processes it's alive
+	HeartbeatInterval = 10 * time.Second
+	// StaleAfter is how long a running sync may go without a heartbeat
+	// before it's considered interrupted
+	StaleAfter = 6 * HeartbeatInterval
+)
+
+// ErrAlreadyRunning is returned when another process is syncing the same image
+var ErrAlreadyRunning = errors.New("already being synced")
+
+// Tracker records sync results. It is safe for concurrent use: every write
+// holds mu, so parallel syncs don't contend for the database lock.
 type Tracker struct {
-	db *storage.DB
+	db storage.DB
+	mu sync.Mutex
+
+	running map[string]*run
+}
+
+// run is a sync recorded as running, kept alive by a heartbeat
+type run struct {
+	id   int
+	stop chan struct{}
+}
+
+func NewTracker(db storage.DB) *Tracker {
+	return &Tracker{db: db, running: make(map[string]*run)}
+}
+
+// RecoverInterrupted marks running syncs that stopped sending heartbeats, e.g.
+// because their process was killed, as interrupted
+func (t *Tracker) RecoverInterrupted() (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.recoverInterrupted()
 }
 
-func NewTracker(db *storage.DB) *Tracker {
-	return &Tracker{db: db}
+func (t *Tracker) recoverInterrupted() (int, error) {
+	return t.db.MarkInterrupted(time.Now().Add(-StaleAfter))
 }
 
+// TrackSyncStart records the sync as running and keeps its heartbeat going
+// until TrackSyncComplete, TrackSyncError or TrackSyncCancelled. It fails with
+// ErrAlreadyRunning when another live process is syncing the image.
 func (t *Tracker) TrackSyncStart(image string) error {
-	// We record a 'pending' or 'started' state
-	// For simplicity, we'll just log it to stdout or maybe insert a record if we want deeper tracking
-	// In this simple version, we mainly care about the result
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, err := t.recoverInterrupted(); err != nil {
+		return err
+	}
+	if _, ok := t.running[image]; ok {
+		return fmt.Errorf("%s is %w", image, ErrAlreadyRunning)
+	}
+
+	id, err := t.db.StartSync(image)
+	if errors.Is(err, storage.ErrSyncRunning) {
+		others, gerr := t.db.GetSyncs(storage.SyncFilter{Image: image, Status: storage.StatusRunning, Limit: 1})
+		if gerr != nil || len(others) == 0 {
+			return fmt.Errorf("%s is %w by another process", image, ErrAlreadyRunning)
+		}
+		return fmt.Errorf("%s is %w by another process (last heartbeat %s ago)",
+			image, ErrAlreadyRunning, time.Since(others[0].Heartbeat).Round(time.Second))
+	}
+	if err != nil {
+		return err
+	}
+	r := &run{id: id, stop: make(chan struct{})}
+	t.running[image] = r
+	go t.heartbeat(r)
 	return nil
 }
 
-func (t *Tracker) TrackSyncComplete(image string, bytes int64, duration time.Duration) error {
-	return t.db.RecordSync(image, "completed", bytes, duration.Seconds())
+func (t *Tracker) heartbeat(r *run) {
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.mu.Lock()
+			// The sync may have finished while we waited for the lock
+			select {
+			case <-r.stop:
+			default:
+				t.db.Heartbeat(r.id)
+			}
+			t.mu.Unlock()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// TrackLayer records the progress of one layer of a running sync. It has the
+// signature Syncer.SetLayerHook expects.
+func (t *Tracker) TrackLayer(image, digest string, size int64, status string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if r, ok := t.running[image]; ok {
+		t.db.RecordLayer(r.id, storage.LayerProgress{Digest: digest, Size: size, Status: status})
+	}
+}
+
+func (t *Tracker) TrackSyncComplete(result *SyncResult) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.finish(result.Record("completed"))
+}
+
+// TrackSyncError records a failed sync with its error category and message,
+// and whatever the sync transferred before failing
+func (t *Tracker) TrackSyncError(result *SyncResult, err error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rec := result.Record("failed")
+	rec.ErrorCategory = string(Classify(err))
+	rec.Error = err.Error()
+	return t.finish(rec)
+}
+
+// TrackSyncCancelled forgets a running sync that was called off before
+// transferring anything, e.g. because the image doesn't fit the cache
+func (t *Tracker) TrackSyncCancelled(image string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r, ok := t.stop(image)
+	if !ok {
+		return nil
+	}
+	return t.db.DeleteSync(r.id)
+}
+
+// TrackSyncInterrupted records a sync that was stopped part way, e.g. with
+// Ctrl-C, so it can be resumed straight away
+func (t *Tracker) TrackSyncInterrupted(result *SyncResult) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.finish(result.Record(storage.StatusInterrupted))
 }
 
-func (t *Tracker) TrackSyncError(image string, err error) error {
-	// Record 0 bytes and 0 duration for errors
-	return t.db.RecordSync(image, fmt.Sprintf("failed: %v", err), 0, 0)
+// finish records the outcome of a sync, over its running record if it has one
+func (t *Tracker) finish(rec storage.SyncRecord) error {
+	if r, ok := t.stop(rec.Image); ok {
+		return t.db.FinishSync(r.id, rec)
+	}
+	return t.db.RecordSync(rec)
+}
+
+// stop ends a running sync's heartbeat. t.mu must be held.
+func (t *Tracker) stop(image string) (*run, bool) {
+	r, ok := t.running[image]
+	if !ok {
+		return nil, false
+	}
+	close(r.stop)
+	delete(t.running, image)
+	return r, true
 }
 
 func (t *Tracker) GetLastStatus(image string) (string, time.Time, error) {
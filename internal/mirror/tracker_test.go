@@ -0,0 +1,131 @@
+package mirror
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/storage"
+)
+
+func TestTrackerRecordsRunningSyncsAndInterruptions(t *testing.T) {
+	db, err := storage.Open("memory://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	daemon, cli := NewTracker(db), NewTracker(db)
+	if err := daemon.TrackSyncStart("nginx:latest"); err != nil {
+		t.Fatal(err)
+	}
+	daemon.TrackLayer("nginx:latest", "sha256:aaa", 100, LayerDone)
+	daemon.TrackLayer("nginx:latest", "sha256:bbb", 50, LayerSkipped)
+
+	// Another process sees the live sync and backs off
+	if err := cli.TrackSyncStart("nginx:latest"); !errors.Is(err, ErrAlreadyRunning) {
+		t.Fatalf("Expected ErrAlreadyRunning, got %v", err)
+	}
+
+	running, _ := db.GetLatestSync("nginx:latest")
+	if running == nil || running.Status != storage.StatusRunning {
+		t.Fatalf("Expected a running record, got %+v", running)
+	}
+	layers, _ := db.GetSyncLayers(running.ID)
+	if len(layers) != 2 {
+		t.Errorf("Expected 2 layers recorded, got %d", len(layers))
+	}
+
+	// The daemon dies: its heartbeat goes stale
+	if n, _ := db.MarkInterrupted(time.Now().Add(time.Second)); n != 1 {
+		t.Fatalf("Expected 1 sync marked interrupted, got %d", n)
+	}
+	interrupted, _ := db.GetInterruptedSyncs()
+	if len(interrupted) != 1 || interrupted[0].Image != "nginx:latest" {
+		t.Fatalf("Expected nginx:latest to be resumable, got %+v", interrupted)
+	}
+
+	// Resuming completes it with a new record
+	if err := cli.TrackSyncStart("nginx:latest"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.TrackSyncComplete(&SyncResult{Image: "nginx:latest", Layers: 2, LayersSkipped: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if latest, _ := db.GetLatestSync("nginx:latest"); latest.Status != "completed" {
+		t.Errorf("Expected completed, got %s", latest.Status)
+	}
+	if interrupted, _ := db.GetInterruptedSyncs(); len(interrupted) != 0 {
+		t.Errorf("Expected nothing left to resume, got %+v", interrupted)
+	}
+}
+
+func TestInterruptedSyncKeepsItsProgress(t *testing.T) {
+	db, err := storage.Open("memory://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tracker := NewTracker(db)
+	if err := tracker.TrackSyncStart("redis:7"); err != nil {
+		t.Fatal(err)
+	}
+	tracker.TrackLayer("redis:7", "sha256:aaa", 100, LayerDone)
+	if err := tracker.TrackSyncInterrupted(&SyncResult{Image: "redis:7", Layers: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	interrupted, _ := db.GetInterruptedSyncs()
+	if len(interrupted) != 1 || interrupted[0].Layers != 3 {
+		t.Fatalf("Expected redis:7 to be resumable, got %+v", interrupted)
+	}
+	if layers, _ := db.GetSyncLayers(interrupted[0].ID); len(layers) != 1 {
+		t.Errorf("Expected the transferred layer to be kept, got %d", len(layers))
+	}
+	// Nothing is left running, so it can be started again straight away
+	if err := tracker.TrackSyncStart("redis:7"); err != nil {
+		t.Errorf("Expected to restart the sync, got %v", err)
+	}
+}
+
+func TestOnlyOneProcessStartsAnImage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mirror.db")
+
+	// Separate handles on one file, like separate processes
+	var trackers []*Tracker
+	for i := 0; i < 4; i++ {
+		db, err := storage.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		trackers = append(trackers, NewTracker(db))
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(trackers))
+	for i, tr := range trackers {
+		wg.Add(1)
+		go func(i int, tr *Tracker) {
+			defer wg.Done()
+			errs[i] = tr.TrackSyncStart("nginx:latest")
+		}(i, tr)
+	}
+	wg.Wait()
+
+	started := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			started++
+		case !errors.Is(err, ErrAlreadyRunning):
+			t.Errorf("Expected ErrAlreadyRunning, got %v", err)
+		}
+	}
+	if started != 1 {
+		t.Errorf("Expected exactly one sync started, got %d", started)
+	}
+}
@@ -0,0 +1,144 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// StatusError is returned when a registry answers with an unexpected status code
+type StatusError struct {
+	Op   string
+	Code int
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body != "" {
+		return fmt.Sprintf("failed to %s: %s (status: %d)", e.Op, e.Body, e.Code)
+	}
+	return fmt.Sprintf("failed to %s: status %d", e.Op, e.Code)
+}
+
+// upstreamDo sends a request to Docker Hub. Docker Hub wants an (anonymous)
+// bearer token per repository even for public images, so on a 401 challenge we
+// fetch one from the advertised realm and retry once.
+func (c *Client) upstreamDo(req *http.Request, name string) (*http.Response, error) {
+	name = UpstreamName(name)
+
+	c.mu.Lock()
+	token := c.tokens[name]
+	c.mu.Unlock()
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+
+	token, err = c.fetchToken(req, challenge, name)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.tokens[name] = token
+	c.mu.Unlock()
+
+	retry := req.Clone(req.Context())
+	retry.Header.Set("Authorization", "Bearer "+token)
+	return c.httpClient.Do(retry)
+}
+
+func (c *Client) fetchToken(req *http.Request, challenge, name string) (string, error) {
+	params := parseChallenge(challenge)
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("unsupported auth challenge: %q", challenge)
+	}
+
+	q := url.Values{}
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull", name)
+	}
+	q.Set("scope", scope)
+
+	tokenReq, err := http.NewRequestWithContext(req.Context(), "GET", realm+"?"+q.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if c.username != "" {
+		tokenReq.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.httpClient.Do(tokenReq)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch auth token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", &StatusError{Op: "fetch auth token", Code: resp.StatusCode}
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
+
+// parseChallenge reads a header like
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseChallenge(header string) map[string]string {
+	params := make(map[string]string)
+	if !strings.HasPrefix(strings.ToLower(header), "bearer ") {
+		return params
+	}
+
+	rest := header[len("bearer "):]
+	for rest != "" {
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(rest[:eq])
+		rest = rest[eq+1:]
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if comma := strings.Index(rest, ","); comma >= 0 {
+			value, rest = rest[:comma], rest[comma:]
+		} else {
+			value, rest = rest, ""
+		}
+
+		params[key] = value
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	return params
+}
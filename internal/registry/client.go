@@ -7,11 +7,21 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 )
 
+const upstreamURL = "https://registry-1.docker.io"
+
 type Client struct {
 	baseURL    string
 	httpClient *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]string // repository -> bearer token for Docker Hub
+
+	// Docker Hub account to fetch tokens with, anonymous if empty
+	username string
+	password string
 }
 
 type Manifest struct {
@@ -19,6 +29,22 @@ type Manifest struct {
 	MediaType     string  `json:"mediaType"`
 	Config        Layer   `json:"config"`
 	Layers        []Layer `json:"layers"`
+
+	// Manifests is only set for manifest lists / OCI indexes
+	Manifests []ManifestDescriptor `json:"manifests,omitempty"`
+}
+
+type ManifestDescriptor struct {
+	MediaType string    `json:"mediaType"`
+	Size      int64     `json:"size"`
+	Digest    string    `json:"digest"`
+	Platform  *Platform `json:"platform,omitempty"`
+}
+
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
 }
 
 type Layer struct {
@@ -31,28 +57,99 @@ func NewClient(registryURL string) *Client {
 	return &Client{
 		baseURL:    registryURL,
 		httpClient: &http.Client{},
+		tokens:     make(map[string]string),
 	}
 }
 
+// SetUpstreamCredentials logs in to Docker Hub with an account (or access
+// token as password) instead of anonymously, for private images and higher rate limits
+func (c *Client) SetUpstreamCredentials(username, password string) {
+	c.username = username
+	c.password = password
+}
+
+// UpstreamName expands Docker Hub's short names, e.g. nginx -> library/nginx
+func UpstreamName(name string) string {
+	if !strings.Contains(name, "/") {
+		return "library/" + name
+	}
+	return name
+}
+
+// ParseReference splits an image reference into repository name and tag (or digest)
+func ParseReference(image string) (string, string) {
+	if i := strings.Index(image, "@"); i >= 0 {
+		return image[:i], image[i+1:]
+	}
+	// A colon before the last slash belongs to a registry host, not a tag
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:]
+	}
+	return image, "latest"
+}
+
+// IsIndex reports whether the manifest is a manifest list / OCI index
+func (m *Manifest) IsIndex() bool {
+	return len(m.Manifests) > 0
+}
+
 // GetManifest fetches the image manifest from the registry
 func (c *Client) GetManifest(ctx context.Context, image string) (*Manifest, error) {
-	parts := strings.Split(image, ":")
-	name := parts[0]
-	tag := "latest"
-	if len(parts) > 1 {
-		tag = parts[1]
+	raw, err := c.GetRawManifest(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+	return raw.Parse()
+}
+
+// GetRawManifest fetches the image manifest from the registry, keeping the
+// original bytes so it can be pushed elsewhere with the same digest
+func (c *Client) GetRawManifest(ctx context.Context, image string) (*RawManifest, error) {
+	name, tag := ParseReference(image)
+	return c.GetUpstreamManifest(ctx, name, tag, MediaTypeDockerManifest)
+}
+
+// GetManifestDigest returns the digest of the manifest GetRawManifest would
+// fetch, with a HEAD request
+func (c *Client) GetManifestDigest(ctx context.Context, image string) (string, error) {
+	name, tag := ParseReference(image)
+	url := fmt.Sprintf("%s/v2/%s/manifests/%s", upstreamURL, UpstreamName(name), tag)
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return "", err
 	}
+	req.Header.Set("Accept", MediaTypeDockerManifest)
 
-	url := fmt.Sprintf("https://registry-1.docker.io/v2/%s/manifests/%s", name, tag)
+	resp, err := c.upstreamDo(req, name)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", &StatusError{Op: "head manifest", Code: resp.StatusCode}
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("no digest for %s", image)
+	}
+	return digest, nil
+}
+
+// GetUpstreamManifest fetches a manifest by tag or digest from Docker Hub,
+// accepting the given media types
+func (c *Client) GetUpstreamManifest(ctx context.Context, name, reference, accept string) (*RawManifest, error) {
+	url := fmt.Sprintf("%s/v2/%s/manifests/%s", upstreamURL, UpstreamName(name), reference)
 
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
+	req.Header.Set("Accept", accept)
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.upstreamDo(req, name)
 	if err != nil {
 		return nil, err
 	}
@@ -60,53 +157,106 @@ func (c *Client) GetManifest(ctx context.Context, image string) (*Manifest, erro
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to get manifest: %s (status: %d)", string(body), resp.StatusCode)
+		return nil, &StatusError{Op: "get manifest", Code: resp.StatusCode, Body: string(body)}
 	}
 
-	var manifest Manifest
-	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
 		return nil, err
 	}
 
-	return &manifest, nil
+	return newRawManifest(data, resp.Header), nil
 }
 
 // PullLayer downloads a specific layer
 func (c *Client) PullLayer(ctx context.Context, image, digest string) (io.ReadCloser, error) {
-	parts := strings.Split(image, ":")
-	name := parts[0]
+	name, _ := ParseReference(image)
 
-	url := fmt.Sprintf("https://registry-1.docker.io/v2/%s/blobs/%s", name, digest)
+	url := fmt.Sprintf("%s/v2/%s/blobs/%s", upstreamURL, UpstreamName(name), digest)
 
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.upstreamDo(req, name)
 	if err != nil {
 		return nil, err
 	}
 
 	if resp.StatusCode != http.StatusOK {
 		resp.Body.Close()
-		return nil, fmt.Errorf("failed to pull layer: status %d", resp.StatusCode)
+		return nil, &StatusError{Op: "pull layer", Code: resp.StatusCode}
 	}
 
 	return resp.Body, nil
 }
 
-// PushLayer uploads a layer to the local registry
+// HeadLayer returns the size of a layer on Docker Hub without downloading it
+func (c *Client) HeadLayer(ctx context.Context, image, digest string) (int64, error) {
+	name, _ := ParseReference(image)
+
+	url := fmt.Sprintf("%s/v2/%s/blobs/%s", upstreamURL, UpstreamName(name), digest)
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := c.upstreamDo(req, name)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, &StatusError{Op: "head layer", Code: resp.StatusCode}
+	}
+	return resp.ContentLength, nil
+}
+
+// ListUpstreamTags returns the tags Docker Hub has for a repository
+func (c *Client) ListUpstreamTags(ctx context.Context, name string) ([]string, error) {
+	url := fmt.Sprintf("%s/v2/%s/tags/list", upstreamURL, UpstreamName(name))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.upstreamDo(req, name)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Op: "list tags", Code: resp.StatusCode}
+	}
+
+	var body struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return body.Tags, nil
+}
+
+// PushLayer uploads a layer to the local registry using a monolithic upload
 func (c *Client) PushLayer(ctx context.Context, image, digest string, data io.Reader) error {
-	parts := strings.Split(image, ":")
-	name := parts[0]
+	name, _ := ParseReference(image)
 
-	url := fmt.Sprintf("http://%s/v2/%s/blobs/%s", c.baseURL, name, digest)
+	location, err := c.startUpload(ctx, name, "")
+	if err != nil {
+		return fmt.Errorf("failed to push layer: %w", err)
+	}
 
-	req, err := http.NewRequestWithContext(ctx, "PUT", url, data)
+	req, err := http.NewRequestWithContext(ctx, "PUT", withDigest(location, digest), data)
 	if err != nil {
 		return err
 	}
+	req.Header.Set("Content-Type", "application/octet-stream")
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -115,7 +265,8 @@ func (c *Client) PushLayer(ctx context.Context, image, digest string, data io.Re
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusAccepted {
-		return fmt.Errorf("failed to push layer: status %d", resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		return &StatusError{Op: "push layer " + digest, Code: resp.StatusCode, Body: strings.TrimSpace(string(body))}
 	}
 
 	return nil
@@ -0,0 +1,121 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// maxConfigSize bounds how much of a config blob is read; real ones are a few KB
+const maxConfigSize = 8 << 20
+
+// ImageConfig is the decoded config blob of an image, as described by the
+// OCI image spec (Docker's format is the same for these fields)
+type ImageConfig struct {
+	Architecture string     `json:"architecture"`
+	OS           string     `json:"os"`
+	Variant      string     `json:"variant,omitempty"`
+	Created      *time.Time `json:"created,omitempty"`
+	Author       string     `json:"author,omitempty"`
+	Config       RunConfig  `json:"config"`
+	RootFS       struct {
+		Type    string   `json:"type"`
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+	History []HistoryEntry `json:"history,omitempty"`
+}
+
+// RunConfig is how containers of the image are run by default
+type RunConfig struct {
+	User         string              `json:"User,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+}
+
+// HistoryEntry is a build step. Steps with EmptyLayer set (ENV, CMD, ...)
+// didn't produce a layer.
+type HistoryEntry struct {
+	Created    *time.Time `json:"created,omitempty"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+	Comment    string     `json:"comment,omitempty"`
+	EmptyLayer bool       `json:"empty_layer,omitempty"`
+}
+
+// GetConfig fetches and decodes an image's config blob from Docker Hub
+func (c *Client) GetConfig(ctx context.Context, image, digest string) (*ImageConfig, error) {
+	body, err := c.PullLayer(ctx, image, digest)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return decodeConfig(body, digest)
+}
+
+// GetLocalConfig fetches and decodes an image's config blob from the local registry
+func (c *Client) GetLocalConfig(ctx context.Context, name, digest string) (*ImageConfig, error) {
+	body, err := c.PullLocalBlob(ctx, name, digest)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return decodeConfig(body, digest)
+}
+
+// decodeConfig reads a config blob, checking it against its digest
+func decodeConfig(r io.Reader, digest string) (*ImageConfig, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxConfigSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config %s: %w", digest, err)
+	}
+	if got := fmt.Sprintf("sha256:%x", sha256.Sum256(data)); strings.HasPrefix(digest, "sha256:") && got != digest {
+		return nil, fmt.Errorf("config blob %s has digest %s", digest, got)
+	}
+
+	var cfg ImageConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to decode config %s: %w", digest, err)
+	}
+	return &cfg, nil
+}
+
+// String formats the platform as os/architecture[/variant], e.g. linux/arm64/v8
+func (p *Platform) String() string {
+	if p == nil {
+		return "unknown"
+	}
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// FindPlatform returns the manifest of an index for a platform given as
+// os/architecture[/variant]. Without a variant any variant matches.
+func (m *Manifest) FindPlatform(platform string) (*ManifestDescriptor, bool) {
+	parts := strings.SplitN(platform, "/", 3)
+	if len(parts) < 2 {
+		return nil, false
+	}
+	for i, d := range m.Manifests {
+		p := d.Platform
+		if p == nil || p.OS != parts[0] || p.Architecture != parts[1] {
+			continue
+		}
+		if len(parts) == 3 && p.Variant != parts[2] {
+			continue
+		}
+		return &m.Manifests[i], true
+	}
+	return nil, false
+}
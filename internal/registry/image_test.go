@@ -0,0 +1,69 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDecodeConfigChecksDigest(t *testing.T) {
+	data := `{"architecture":"arm64","os":"linux","variant":"v8",` +
+		`"config":{"Env":["PATH=/usr/bin"],"Entrypoint":["/docker-entrypoint.sh"],"ExposedPorts":{"80/tcp":{}}},` +
+		`"history":[{"created_by":"/bin/sh -c #(nop) ADD file:abc in / "},{"created_by":"/bin/sh -c #(nop)  CMD [\"nginx\"]","empty_layer":true}]}`
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(data)))
+
+	cfg, err := decodeConfig(strings.NewReader(data), digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Architecture != "arm64" || cfg.Variant != "v8" || len(cfg.Config.Env) != 1 || len(cfg.History) != 2 {
+		t.Errorf("Unexpected config %+v", cfg)
+	}
+	if _, ok := cfg.Config.ExposedPorts["80/tcp"]; !ok || !cfg.History[1].EmptyLayer {
+		t.Errorf("Expected the exposed port and an empty history step, got %+v", cfg)
+	}
+
+	if _, err := decodeConfig(strings.NewReader(data+" "), digest); err == nil || !strings.Contains(err.Error(), "has digest") {
+		t.Errorf("Expected tampered content to fail the digest check, got %v", err)
+	}
+	if _, err := decodeConfig(strings.NewReader("not json"), fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("not json")))); err == nil {
+		t.Error("Expected a decode error")
+	}
+}
+
+func TestFindPlatform(t *testing.T) {
+	index := &Manifest{Manifests: []ManifestDescriptor{
+		{Digest: "sha256:amd64", Platform: &Platform{OS: "linux", Architecture: "amd64"}},
+		{Digest: "sha256:armv6", Platform: &Platform{OS: "linux", Architecture: "arm", Variant: "v6"}},
+		{Digest: "sha256:armv7", Platform: &Platform{OS: "linux", Architecture: "arm", Variant: "v7"}},
+		{Digest: "sha256:attestation", Platform: &Platform{OS: "unknown", Architecture: "unknown"}},
+		{Digest: "sha256:none"},
+	}}
+
+	cases := map[string]string{
+		"linux/amd64":   "sha256:amd64",
+		"linux/arm/v7":  "sha256:armv7",
+		"linux/arm":     "sha256:armv6", // without a variant the first one matches
+		"linux/arm/v8":  "",
+		"linux/arm64":   "",
+		"windows/amd64": "",
+		"linux":         "",
+	}
+	for platform, want := range cases {
+		d, ok := index.FindPlatform(platform)
+		switch {
+		case want == "" && ok:
+			t.Errorf("FindPlatform(%q) = %s, want no match", platform, d.Digest)
+		case want != "" && (!ok || d.Digest != want):
+			t.Errorf("FindPlatform(%q) = %v, want %s", platform, d, want)
+		}
+	}
+
+	if got := index.Manifests[2].Platform.String(); got != "linux/arm/v7" {
+		t.Errorf("Expected linux/arm/v7, got %s", got)
+	}
+	if got := index.Manifests[4].Platform.String(); got != "unknown" {
+		t.Errorf("Expected a missing platform to print as unknown, got %s", got)
+	}
+}
@@ -0,0 +1,273 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+// ManifestAccept lists every manifest flavour we know how to handle
+var ManifestAccept = strings.Join([]string{
+	MediaTypeDockerManifest,
+	MediaTypeDockerManifestList,
+	MediaTypeOCIManifest,
+	MediaTypeOCIIndex,
+}, ", ")
+
+// RawManifest is a manifest exactly as stored in a registry, so it can be
+// re-pushed without changing its digest
+type RawManifest struct {
+	Digest    string
+	MediaType string
+	Data      []byte
+}
+
+func newRawManifest(data []byte, header http.Header) *RawManifest {
+	digest := header.Get("Docker-Content-Digest")
+	if digest == "" {
+		digest = fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	}
+	return &RawManifest{Digest: digest, MediaType: header.Get("Content-Type"), Data: data}
+}
+
+// Parse decodes the raw bytes into a Manifest
+func (r *RawManifest) Parse() (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(r.Data, &m); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest %s: %w", r.Digest, err)
+	}
+	return &m, nil
+}
+
+func (c *Client) localURL(format string, args ...interface{}) string {
+	return fmt.Sprintf("http://%s/v2/", c.baseURL) + fmt.Sprintf(format, args...)
+}
+
+// ListRepositories returns every repository held by the local registry
+func (c *Client) ListRepositories(ctx context.Context) ([]string, error) {
+	var body struct {
+		Repositories []string `json:"repositories"`
+	}
+	if err := c.getJSON(ctx, c.localURL("_catalog?n=10000"), &body); err != nil {
+		return nil, fmt.Errorf("failed to list repositories: %w", err)
+	}
+	return body.Repositories, nil
+}
+
+// ListTags returns the tags of a repository in the local registry
+func (c *Client) ListTags(ctx context.Context, name string) ([]string, error) {
+	var body struct {
+		Tags []string `json:"tags"`
+	}
+	if err := c.getJSON(ctx, c.localURL("%s/tags/list", name), &body); err != nil {
+		return nil, fmt.Errorf("failed to list tags for %s: %w", name, err)
+	}
+	return body.Tags, nil
+}
+
+// GetLocalManifest fetches a manifest (by tag or digest) from the local registry
+func (c *Client) GetLocalManifest(ctx context.Context, name, reference string) (*RawManifest, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.localURL("%s/manifests/%s", name, reference), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", ManifestAccept)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Op: fmt.Sprintf("get manifest %s:%s", name, reference), Code: resp.StatusCode}
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return newRawManifest(data, resp.Header), nil
+}
+
+// PushManifest uploads a manifest to the local registry under a tag or digest
+// and returns the digest the registry stored it under
+func (c *Client) PushManifest(ctx context.Context, name, reference string, manifest *RawManifest) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "PUT", c.localURL("%s/manifests/%s", name, reference), bytes.NewReader(manifest.Data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", manifest.MediaType)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return "", &StatusError{Op: fmt.Sprintf("push manifest %s:%s", name, reference), Code: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+	return resp.Header.Get("Docker-Content-Digest"), nil
+}
+
+// ErrDeleteDisabled is returned when the local registry refuses deletes
+var ErrDeleteDisabled = errors.New("the registry has deletes disabled, start it with REGISTRY_STORAGE_DELETE_ENABLED=true")
+
+// DeleteManifest removes a manifest, and every tag pointing at it, from the
+// local registry. Its blobs stay on disk until the registry's garbage collector runs.
+func (c *Client) DeleteManifest(ctx context.Context, name, digest string) error {
+	req, err := http.NewRequestWithContext(ctx, "DELETE", c.localURL("%s/manifests/%s", name, digest), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusAccepted, http.StatusOK:
+		return nil
+	case http.StatusMethodNotAllowed:
+		return ErrDeleteDisabled
+	default:
+		body, _ := io.ReadAll(resp.Body)
+		return &StatusError{Op: fmt.Sprintf("delete manifest %s@%s", name, digest), Code: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+}
+
+// BlobExists checks whether the local registry has a blob in the given repository
+func (c *Client) BlobExists(ctx context.Context, name, digest string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", c.localURL("%s/blobs/%s", name, digest), nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("failed to check blob %s: status %d", digest, resp.StatusCode)
+	}
+}
+
+// PullLocalBlob downloads a blob from the local registry
+func (c *Client) PullLocalBlob(ctx context.Context, name, digest string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.localURL("%s/blobs/%s", name, digest), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to pull blob %s: status %d", digest, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// MountBlob links a blob that already exists in another repository of the
+// local registry into name, without transferring any data
+func (c *Client) MountBlob(ctx context.Context, name, digest, from string) error {
+	location, err := c.startUpload(ctx, name, "mount="+url.QueryEscape(digest)+"&from="+url.QueryEscape(from))
+	if err != nil {
+		return fmt.Errorf("failed to mount blob %s from %s: %w", digest, from, err)
+	}
+	if location != "" {
+		// The registry fell back to a regular upload session, so the blob wasn't mountable
+		return fmt.Errorf("failed to mount blob %s from %s: blob not found", digest, from)
+	}
+	return nil
+}
+
+// startUpload opens an upload session and returns its location. An empty
+// location means the registry completed the request straight away (a mount).
+func (c *Client) startUpload(ctx context.Context, name, query string) (string, error) {
+	u := c.localURL("%s/blobs/uploads/", name)
+	if query != "" {
+		u += "?" + query
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", u, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return "", nil
+	case http.StatusAccepted:
+	default:
+		body, _ := io.ReadAll(resp.Body)
+		return "", &StatusError{Op: "start upload to " + name, Code: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+
+	location := resp.Header.Get("Location")
+	if strings.HasPrefix(location, "/") {
+		location = fmt.Sprintf("http://%s%s", c.baseURL, location)
+	}
+	return location, nil
+}
+
+func withDigest(location, digest string) string {
+	sep := "?"
+	if strings.Contains(location, "?") {
+		sep = "&"
+	}
+	return location + sep + "digest=" + url.QueryEscape(digest)
+}
+
+func (c *Client) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,437 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/blobstore"
+	"github.com/saurabh12nxf/registry-mirror/internal/registry"
+	"github.com/saurabh12nxf/registry-mirror/internal/storage"
+)
+
+// Server implements the read side of the distribution API on top of the
+// blob store, fetching from Docker Hub whenever something isn't cached yet
+type Server struct {
+	client *registry.Client
+	store  *blobstore.Store
+	db     storage.DB
+	tagTTL time.Duration
+
+	onPull func(image string)
+
+	mu    sync.Mutex
+	locks map[string]*keyLock
+	// heads holds when a client last checked a tag with HEAD, see countPull
+	heads map[string]time.Time
+}
+
+// headWindow is how long a GET by tag after a HEAD of the same tag by the
+// same client counts as part of the same pull
+const headWindow = time.Minute
+
+// New creates a pull-through server. Cached tags are revalidated against
+// upstream once they are older than tagTTL. db may be nil to skip pull recording.
+func New(client *registry.Client, store *blobstore.Store, db storage.DB, tagTTL time.Duration) *Server {
+	return &Server{
+		client: client,
+		store:  store,
+		db:     db,
+		tagTTL: tagTTL,
+		locks:  make(map[string]*keyLock),
+		heads:  make(map[string]time.Time),
+	}
+}
+
+// SetPullHook registers a function called (synchronously) after every pull by tag
+func (s *Server) SetPullHook(fn func(image string)) {
+	s.onPull = fn
+}
+
+// Warm fetches an image into the store ahead of time: its manifest, the
+// manifest for this host's platform if it's an index, and all layers
+func (s *Server) Warm(ctx context.Context, image string) error {
+	name, ref := registry.ParseReference(image)
+
+	raw, _, err := s.resolveManifest(ctx, name, ref)
+	if err != nil {
+		return err
+	}
+	m, err := raw.Parse()
+	if err != nil {
+		return err
+	}
+
+	for _, child := range m.Manifests {
+		if child.Platform == nil || child.Platform.OS != "linux" || child.Platform.Architecture != runtime.GOARCH {
+			continue
+		}
+		if raw, _, err = s.resolveManifest(ctx, name, child.Digest); err != nil {
+			return err
+		}
+		if m, err = raw.Parse(); err != nil {
+			return err
+		}
+		break
+	}
+
+	blobs := m.Layers
+	if m.Config.Digest != "" {
+		blobs = append(blobs, m.Config)
+	}
+	for _, b := range blobs {
+		if err := s.fetchBlob(ctx, name, b.Digest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "this mirror is read-only")
+		return
+	}
+
+	path := r.URL.Path
+	if path == "/v2/" || path == "/v2" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+		return
+	}
+	if !strings.HasPrefix(path, "/v2/") {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "not found")
+		return
+	}
+	rest := strings.TrimPrefix(path, "/v2/")
+
+	if strings.HasSuffix(rest, "/tags/list") {
+		s.handleTags(w, r, strings.TrimSuffix(rest, "/tags/list"))
+		return
+	}
+	if i := strings.LastIndex(rest, "/manifests/"); i > 0 {
+		s.handleManifest(w, r, rest[:i], rest[i+len("/manifests/"):])
+		return
+	}
+	if i := strings.LastIndex(rest, "/blobs/"); i > 0 {
+		s.handleBlob(w, r, rest[:i], rest[i+len("/blobs/"):])
+		return
+	}
+
+	writeError(w, http.StatusNotFound, "NOT_FOUND", "not found")
+}
+
+func (s *Server) handleManifest(w http.ResponseWriter, r *http.Request, name, ref string) {
+	raw, hit, err := s.resolveManifest(r.Context(), name, ref)
+	if err != nil {
+		upstreamError(w, err, "MANIFEST_UNKNOWN")
+		return
+	}
+
+	w.Header().Set("Content-Type", raw.MediaType)
+	w.Header().Set("Docker-Content-Digest", raw.Digest)
+	w.Header().Set("Content-Length", strconv.Itoa(len(raw.Data)))
+
+	if r.Method != http.MethodHead {
+		w.Write(raw.Data)
+	}
+
+	// Resolving a tag is what a `docker pull` looks like; child manifests and
+	// blobs fetched afterwards are part of the same pull. containerd and
+	// recent Docker versions resolve it with HEAD and then GET by digest.
+	if !isDigest(ref) && s.countPull(r, name, ref) {
+		s.recordPull(r, name, ref, raw.Digest, hit)
+	}
+}
+
+// countPull reports whether a request for a tag is a new pull, rather than
+// the GET some clients send after checking the same tag with HEAD
+func (s *Server) countPull(r *http.Request, name, tag string) bool {
+	key := clientIP(r) + " " + name + ":" + tag
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, at := range s.heads {
+		if now.Sub(at) > headWindow {
+			delete(s.heads, k)
+		}
+	}
+
+	if r.Method == http.MethodHead {
+		s.heads[key] = now
+		return true
+	}
+	if _, ok := s.heads[key]; ok {
+		delete(s.heads, key)
+		return false
+	}
+	return true
+}
+
+// resolveManifest returns the manifest from the store, going upstream on a
+// miss or when a cached tag is older than the TTL. hit is false when upstream
+// had to be contacted.
+func (s *Server) resolveManifest(ctx context.Context, name, ref string) (*registry.RawManifest, bool, error) {
+	key := name + ":" + ref
+	if isDigest(ref) {
+		key = name + "@" + ref
+	}
+
+	cached, ok := s.store.Image(key)
+	if ok && s.store.Has(cached.Manifest) {
+		fresh := isDigest(ref) || time.Since(cached.Updated) < s.tagTTL
+		if fresh {
+			raw, err := s.readManifest(cached)
+			return raw, true, err
+		}
+	} else {
+		ok = false
+	}
+
+	raw, err := s.client.GetUpstreamManifest(ctx, name, ref, registry.ManifestAccept)
+	if err != nil {
+		if ok {
+			// Upstream is unreachable or erroring, a stale tag beats no tag
+			raw, rerr := s.readManifest(cached)
+			return raw, true, rerr
+		}
+		return nil, false, err
+	}
+
+	if err := s.storeManifest(key, raw); err != nil {
+		return nil, false, err
+	}
+	return raw, false, nil
+}
+
+func (s *Server) readManifest(refs blobstore.ImageRefs) (*registry.RawManifest, error) {
+	data, err := s.store.ReadBytes(refs.Manifest)
+	if err != nil {
+		return nil, err
+	}
+	return &registry.RawManifest{Digest: refs.Manifest, MediaType: refs.MediaType, Data: data}, nil
+}
+
+// storeManifest caches a manifest and records what it references so the
+// store's reference counting keeps its content alive
+func (s *Server) storeManifest(key string, raw *registry.RawManifest) error {
+	if err := s.store.PutBytes(raw.Digest, raw.Data); err != nil {
+		return err
+	}
+
+	m, err := raw.Parse()
+	if err != nil {
+		return err
+	}
+
+	var blobs []string
+	for _, child := range m.Manifests {
+		blobs = append(blobs, child.Digest)
+	}
+	if m.Config.Digest != "" {
+		blobs = append(blobs, m.Config.Digest)
+	}
+	for _, l := range m.Layers {
+		blobs = append(blobs, l.Digest)
+	}
+
+	return s.store.SetImage(key, blobstore.ImageRefs{
+		Manifest:  raw.Digest,
+		MediaType: raw.MediaType,
+		Blobs:     blobs,
+	})
+}
+
+func (s *Server) handleBlob(w http.ResponseWriter, r *http.Request, name, digest string) {
+	if !isDigest(digest) {
+		writeError(w, http.StatusBadRequest, "DIGEST_INVALID", "invalid digest")
+		return
+	}
+
+	if r.Method == http.MethodHead && !s.store.Has(digest) {
+		// Checking for a blob shouldn't download it
+		size, err := s.client.HeadLayer(r.Context(), name, digest)
+		if err != nil {
+			upstreamError(w, err, "BLOB_UNKNOWN")
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		return
+	}
+
+	if err := s.fetchBlob(r.Context(), name, digest); err != nil {
+		upstreamError(w, err, "BLOB_UNKNOWN")
+		return
+	}
+
+	f, size, err := s.store.Get(digest)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+
+	if r.Method == http.MethodHead {
+		return
+	}
+	io.Copy(w, f)
+}
+
+// fetchBlob makes sure a blob is in the store. Concurrent requests for the
+// same missing blob share a single upstream download.
+func (s *Server) fetchBlob(ctx context.Context, name, digest string) error {
+	if s.store.Has(digest) {
+		return nil
+	}
+
+	unlock := s.lock(digest)
+	defer unlock()
+
+	if s.store.Has(digest) {
+		return nil
+	}
+
+	data, err := s.client.PullLayer(ctx, name, digest)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	_, err = s.store.Put(digest, data)
+	return err
+}
+
+// keyLock is a lock on one key, counting who holds or waits for it so it
+// can be dropped once nobody does
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock takes the lock on key and returns the function that releases it
+func (s *Server) lock(key string) func() {
+	s.mu.Lock()
+	l, ok := s.locks[key]
+	if !ok {
+		l = &keyLock{}
+		s.locks[key] = l
+	}
+	l.refs++
+	s.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if l.refs--; l.refs == 0 {
+			delete(s.locks, key)
+		}
+	}
+}
+
+func (s *Server) handleTags(w http.ResponseWriter, r *http.Request, name string) {
+	tags, err := s.client.ListUpstreamTags(r.Context(), name)
+	if err != nil {
+		// Offline: answer with the tags we have cached
+		prefix := name + ":"
+		tags = nil
+		for _, img := range s.store.Images() {
+			if strings.HasPrefix(img, prefix) {
+				tags = append(tags, strings.TrimPrefix(img, prefix))
+			}
+		}
+		if len(tags) == 0 {
+			upstreamError(w, err, "NAME_UNKNOWN")
+			return
+		}
+	}
+	sort.Strings(tags)
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodHead {
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"name": name, "tags": tags})
+}
+
+func (s *Server) recordPull(r *http.Request, name, tag, digest string, hit bool) {
+	ip := clientIP(r)
+
+	status := "miss"
+	if hit {
+		status = "hit"
+	}
+	fmt.Printf("📥 %s %s:%s (%s)\n", ip, name, tag, status)
+
+	if s.onPull != nil {
+		s.onPull(name + ":" + tag)
+	}
+
+	if s.db == nil {
+		return
+	}
+	if err := s.db.RecordPull(storage.PullRecord{
+		Image:    name,
+		Tag:      tag,
+		Digest:   digest,
+		ClientIP: ip,
+		CacheHit: hit,
+	}); err != nil {
+		fmt.Printf("⚠️  Failed to record pull: %v\n", err)
+	}
+}
+
+func clientIP(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		return strings.TrimSpace(strings.Split(fwd, ",")[0])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func isDigest(ref string) bool {
+	return strings.HasPrefix(ref, "sha256:")
+}
+
+// upstreamError maps an upstream failure onto a distribution API error
+func upstreamError(w http.ResponseWriter, err error, notFoundCode string) {
+	var statusErr *registry.StatusError
+	if errors.As(err, &statusErr) && (statusErr.Code == http.StatusNotFound || statusErr.Code == http.StatusUnauthorized) {
+		// Docker Hub answers 401 for repositories that don't exist
+		writeError(w, http.StatusNotFound, notFoundCode, err.Error())
+		return
+	}
+	writeError(w, http.StatusBadGateway, "UNAVAILABLE", err.Error())
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/blobstore"
+	"github.com/saurabh12nxf/registry-mirror/internal/registry"
+)
+
+func digestOf(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+// newCachedServer returns a server whose store already holds library/nginx:latest,
+// so requests can be answered without reaching Docker Hub
+func newCachedServer(t *testing.T) (*Server, string, string) {
+	store, err := blobstore.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layer := []byte("layer data")
+	layerDigest := digestOf(layer)
+	store.PutBytes(layerDigest, layer)
+
+	manifest := []byte(`{"schemaVersion":2,"layers":[{"digest":"` + layerDigest + `","size":10}]}`)
+	manifestDigest := digestOf(manifest)
+	store.PutBytes(manifestDigest, manifest)
+	store.SetImage("library/nginx:latest", blobstore.ImageRefs{
+		Manifest:  manifestDigest,
+		MediaType: registry.MediaTypeDockerManifest,
+		Blobs:     []string{layerDigest},
+	})
+
+	return New(registry.NewClient("localhost:5000"), store, nil, time.Hour), manifestDigest, layerDigest
+}
+
+func TestBaseEndpoint(t *testing.T) {
+	srv, _, _ := newCachedServer(t)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("GET", "/v2/", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", rec.Code)
+	}
+	if rec.Header().Get("Docker-Distribution-API-Version") != "registry/2.0" {
+		t.Error("Missing API version header")
+	}
+}
+
+func TestServesCachedManifestAndBlob(t *testing.T) {
+	srv, manifestDigest, layerDigest := newCachedServer(t)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("GET", "/v2/library/nginx/manifests/latest", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for cached manifest, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Docker-Content-Digest"); got != manifestDigest {
+		t.Errorf("Expected digest %s, got %s", manifestDigest, got)
+	}
+	if got := rec.Header().Get("Content-Type"); got != registry.MediaTypeDockerManifest {
+		t.Errorf("Expected media type %s, got %s", registry.MediaTypeDockerManifest, got)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("HEAD", "/v2/library/nginx/blobs/"+layerDigest, nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Length") != "10" {
+		t.Errorf("Expected HEAD of cached blob to succeed, got %d (length %s)", rec.Code, rec.Header().Get("Content-Length"))
+	}
+}
+
+func TestRejectsWrites(t *testing.T) {
+	srv, _, _ := newCachedServer(t)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest("PUT", "/v2/library/nginx/manifests/latest", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", rec.Code)
+	}
+}
+
+func TestRecordsPullsResolvedWithHead(t *testing.T) {
+	srv, manifestDigest, _ := newCachedServer(t)
+	var pulls []string
+	srv.SetPullHook(func(image string) { pulls = append(pulls, image) })
+
+	get := func(method, ref string) {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(method, "/v2/library/nginx/manifests/"+ref, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200 for %s %s, got %d", method, ref, rec.Code)
+		}
+	}
+
+	refs, _ := srv.store.Image("library/nginx:latest")
+	srv.store.SetImage("library/nginx@"+manifestDigest, refs)
+
+	// containerd: HEAD by tag, then GET by digest
+	get("HEAD", "latest")
+	get("GET", manifestDigest)
+	if len(pulls) != 1 || pulls[0] != "library/nginx:latest" {
+		t.Fatalf("Expected the HEAD to count as a pull, got %v", pulls)
+	}
+
+	// A GET of the tag right after its HEAD is the same pull
+	get("HEAD", "latest")
+	get("GET", "latest")
+	if len(pulls) != 2 {
+		t.Errorf("Expected HEAD and GET of a tag to count once, got %v", pulls)
+	}
+
+	// Older clients GET the tag straight away
+	get("GET", "latest")
+	if len(pulls) != 3 {
+		t.Errorf("Expected a GET by tag to count, got %v", pulls)
+	}
+}
+
+func TestLocksAreDroppedOnceReleased(t *testing.T) {
+	srv, _, _ := newCachedServer(t)
+
+	unlock := srv.lock("sha256:abc")
+	done := make(chan struct{})
+	go func() {
+		// Waits for the first holder, sharing its lock
+		srv.lock("sha256:abc")()
+		close(done)
+	}()
+	for {
+		srv.mu.Lock()
+		waiting := srv.locks["sha256:abc"].refs == 2
+		srv.mu.Unlock()
+		if waiting {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	unlock()
+	<-done
+	if n := len(srv.locks); n != 0 {
+		t.Errorf("Expected no locks left, got %d", n)
+	}
+}
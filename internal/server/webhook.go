@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/registry"
+	"github.com/saurabh12nxf/registry-mirror/internal/storage"
+)
+
+// Envelope is the body registry:2 POSTs to notification endpoints
+type Envelope struct {
+	Events []Event `json:"events"`
+}
+
+type Event struct {
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Action    string    `json:"action"`
+	Target    struct {
+		MediaType  string `json:"mediaType"`
+		Digest     string `json:"digest"`
+		Repository string `json:"repository"`
+		Tag        string `json:"tag"`
+	} `json:"target"`
+	Request struct {
+		Addr      string `json:"addr"`
+		UserAgent string `json:"useragent"`
+	} `json:"request"`
+}
+
+// WebhookHandler records pulls reported by a registry:2 notification endpoint
+type WebhookHandler struct {
+	db     storage.DB
+	onPull func(image string)
+}
+
+func NewWebhookHandler(db storage.DB) *WebhookHandler {
+	return &WebhookHandler{db: db}
+}
+
+// SetPullHook registers a function called after every recorded pull
+func (h *WebhookHandler) SetPullHook(fn func(image string)) {
+	h.onPull = fn
+}
+
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var env Envelope
+	if err := json.NewDecoder(r.Body).Decode(&env); err != nil {
+		http.Error(w, "invalid notification: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var pulls []storage.PullRecord
+	for _, ev := range env.Events {
+		if rec, ok := pullFromEvent(ev); ok {
+			pulls = append(pulls, rec)
+		}
+	}
+	if len(pulls) == 0 {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// A non-2xx makes the registry retry the whole envelope later, so it's
+	// recorded all at once or not at all
+	if err := h.db.RecordPulls(pulls); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("📥 Recorded %d pull(s) from registry notifications\n", len(pulls))
+
+	if h.onPull != nil {
+		for _, rec := range pulls {
+			h.onPull(rec.Image + ":" + rec.Tag)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// pullFromEvent turns a notification event into a pull record. The registry
+// emits a pull event for every manifest and blob fetched, but only a manifest
+// fetched by tag marks the start of an actual `docker pull`.
+func pullFromEvent(ev Event) (storage.PullRecord, bool) {
+	if ev.Action != "pull" || ev.Target.Tag == "" || !isManifestType(ev.Target.MediaType) {
+		return storage.PullRecord{}, false
+	}
+
+	ip := ev.Request.Addr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	return storage.PullRecord{
+		Image:    ev.Target.Repository,
+		Tag:      ev.Target.Tag,
+		Digest:   ev.Target.Digest,
+		ClientIP: ip,
+		// The registry only notifies about content it served itself
+		CacheHit:  true,
+		Timestamp: ev.Timestamp,
+	}, true
+}
+
+func isManifestType(mediaType string) bool {
+	switch mediaType {
+	case registry.MediaTypeDockerManifest, registry.MediaTypeDockerManifestList,
+		registry.MediaTypeOCIManifest, registry.MediaTypeOCIIndex:
+		return true
+	}
+	return strings.Contains(mediaType, "manifest")
+}
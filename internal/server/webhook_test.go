@@ -0,0 +1,79 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/registry"
+	"github.com/saurabh12nxf/registry-mirror/internal/storage"
+)
+
+func TestPullFromEvent(t *testing.T) {
+	ev := Event{Action: "pull"}
+	ev.Target.MediaType = registry.MediaTypeDockerManifest
+	ev.Target.Repository = "library/postgres"
+	ev.Target.Tag = "16"
+	ev.Request.Addr = "10.0.0.7:51234"
+
+	rec, ok := pullFromEvent(ev)
+	if !ok {
+		t.Fatal("Expected manifest pull by tag to be recorded")
+	}
+	if rec.Image != "library/postgres" || rec.Tag != "16" || rec.ClientIP != "10.0.0.7" {
+		t.Errorf("Unexpected record %+v", rec)
+	}
+}
+
+func TestPullFromEventIgnoresBlobsAndPushes(t *testing.T) {
+	blob := Event{Action: "pull"}
+	blob.Target.MediaType = "application/octet-stream"
+	blob.Target.Tag = "16"
+	if _, ok := pullFromEvent(blob); ok {
+		t.Error("Blob fetches should not count as pulls")
+	}
+
+	push := Event{Action: "push"}
+	push.Target.MediaType = registry.MediaTypeDockerManifest
+	push.Target.Tag = "16"
+	if _, ok := pullFromEvent(push); ok {
+		t.Error("Pushes should not count as pulls")
+	}
+}
+
+func TestWebhookRecordsAnEnvelopeAtOnce(t *testing.T) {
+	db, err := storage.Open("memory://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var hooked []string
+	h := NewWebhookHandler(db)
+	h.SetPullHook(func(image string) { hooked = append(hooked, image) })
+
+	body := `{"events":[
+		{"action":"pull","target":{"mediaType":"` + registry.MediaTypeDockerManifest + `","repository":"library/redis","tag":"7"}},
+		{"action":"pull","target":{"mediaType":"application/octet-stream","repository":"library/redis","digest":"sha256:abc"}},
+		{"action":"pull","target":{"mediaType":"` + registry.MediaTypeDockerManifest + `","repository":"library/postgres","tag":"16"}}]}`
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/events", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+
+	pulls, _ := db.GetPulls(time.Time{})
+	if len(pulls) != 2 || len(hooked) != 2 {
+		t.Errorf("Expected 2 pulls recorded and hooked, got %d and %v", len(pulls), hooked)
+	}
+
+	// Nothing is recorded when the envelope can't be stored
+	db.Close()
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/events", strings.NewReader(body)))
+	if rec.Code != http.StatusInternalServerError || len(hooked) != 2 {
+		t.Errorf("Expected a 500 without hooks, got %d and %v", rec.Code, hooked)
+	}
+}
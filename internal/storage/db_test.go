@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentWritersDontLoseRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mirror.db")
+
+	// Two handles on the same file, like a daemon and a CLI sync
+	var handles []DB
+	for i := 0; i < 2; i++ {
+		db, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		handles = append(handles, db)
+	}
+
+	const perWriter = 25
+	var wg sync.WaitGroup
+	errs := make(chan error, 4*perWriter)
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(db DB) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				if err := db.RecordSync(SyncRecord{Image: "nginx:latest", Status: "completed", Bytes: 1, Duration: 0}); err != nil {
+					errs <- err
+				}
+			}
+		}(handles[w%2])
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("Write failed: %v", err)
+	}
+	if records, _ := handles[0].GetRecentSyncs(1000); len(records) != 4*perWriter {
+		t.Errorf("Expected %d records, got %d", 4*perWriter, len(records))
+	}
+}
+
+func TestPostgresPlaceholders(t *testing.T) {
+	got := postgresDialect.rebind(`SELECT id FROM tags WHERE name = ? AND repository_id = ?`)
+	want := `SELECT id FROM tags WHERE name = $1 AND repository_id = $2`
+	if got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestTimesCompareAcrossZones(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zones.db")
+	db, err := OpenUnmigrated(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	sdb := db.(*sqlDB)
+
+	// A sync written before times were stored in UTC, at 10:00Z in UTC+10
+	if err := sdb.ensureVersionTable(); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range migrations[:6] {
+		if _, err := sdb.apply(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := sdb.conn.Exec(`INSERT INTO syncs (image, status, timestamp) VALUES ('old:1', 'completed', '2026-10-01 20:00:00+10:00')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 12:00Z in UTC-10, and 14:00Z (webhooks record UTC)
+	hawaii := time.FixedZone("HST", -10*3600)
+	db.RecordSync(SyncRecord{Image: "new:1", Status: "completed", Timestamp: time.Date(2026, 10, 1, 2, 0, 0, 0, hawaii)})
+	db.RecordSync(SyncRecord{Image: "hook:1", Status: "completed", Timestamp: time.Date(2026, 10, 1, 14, 0, 0, 0, time.UTC)})
+
+	// As parsed from --since 2026-10-01T11:00:00Z
+	since := time.Date(2026, 10, 1, 11, 0, 0, 0, time.UTC)
+	records, err := db.GetSyncs(SyncFilter{Since: since})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected the syncs at 12:00Z and 14:00Z, got %+v", records)
+	}
+	for _, rec := range records {
+		if rec.Image == "old:1" {
+			t.Errorf("Expected the 10:00Z sync to be filtered out, got %+v", rec)
+		}
+	}
+
+	// 11:00Z again, in another zone
+	until := time.Date(2026, 10, 1, 13, 0, 0, 0, time.FixedZone("CEST", 2*3600))
+	if records, _ := db.GetSyncs(SyncFilter{Until: until}); len(records) != 1 || records[0].Image != "old:1" {
+		t.Errorf("Expected only the 10:00Z sync before 11:00Z, got %+v", records)
+	}
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"database/sql"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dialect holds what differs between the SQL databases the mirror can store
+// its state in. Queries are written with ? placeholders and SQLite/PostgreSQL
+// common syntax, and rebound per dialect.
+type dialect struct {
+	name string
+	// numbered placeholders ($1, $2, ...) instead of ?
+	numbered bool
+	// autoID is the column type of an auto-incrementing primary key
+	autoID string
+	// timestamp is the column type of a point in time
+	timestamp string
+	// utc converts a stored time column to UTC, for {{utc column}}
+	utc string
+}
+
+var (
+	sqliteDialect = dialect{
+		name:      "sqlite",
+		autoID:    "INTEGER PRIMARY KEY AUTOINCREMENT",
+		timestamp: "DATETIME",
+		// The format go-sqlite3 writes, with the zone SQLite normalized away
+		utc: `strftime('%Y-%m-%d %H:%M:%f+00:00', $1)`,
+	}
+	postgresDialect = dialect{
+		name:      "postgres",
+		numbered:  true,
+		autoID:    "BIGSERIAL PRIMARY KEY",
+		timestamp: "TIMESTAMPTZ",
+		// TIMESTAMPTZ is stored as a point in time already
+		utc: `$1`,
+	}
+)
+
+// rebind rewrites ? placeholders for the dialect
+func (d dialect) rebind(query string) string {
+	if !d.numbered || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+var utcColumn = regexp.MustCompile(`\{\{utc (\w+)\}\}`)
+
+// schema fills in the dialect's column types in a migration
+func (d dialect) schema(sql string) string {
+	sql = utcColumn.ReplaceAllString(sql, d.utc)
+	return strings.NewReplacer("{{id}}", d.autoID, "{{time}}", d.timestamp).Replace(sql)
+}
+
+// utcArgs converts times to UTC. SQLite stores times as text and compares
+// them as strings, which only orders them correctly if they share an offset.
+func utcArgs(args []interface{}) []interface{} {
+	for i, arg := range args {
+		if t, ok := arg.(time.Time); ok {
+			args[i] = t.UTC()
+		}
+	}
+	return args
+}
+
+func (db *sqlDB) exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.conn.Exec(db.dialect.rebind(query), utcArgs(args)...)
+}
+
+func (db *sqlDB) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.conn.Query(db.dialect.rebind(query), utcArgs(args)...)
+}
+
+func (db *sqlDB) queryRow(query string, args ...interface{}) *sql.Row {
+	return db.conn.QueryRow(db.dialect.rebind(query), utcArgs(args)...)
+}
+
+// txn is a transaction whose queries are rebound like the database's
+type txn struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (db *sqlDB) begin() (*txn, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &txn{Tx: tx, dialect: db.dialect}, nil
+}
+
+func (tx *txn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.rebind(query), utcArgs(args)...)
+}
+
+func (tx *txn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(tx.dialect.rebind(query), utcArgs(args)...)
+}
@@ -0,0 +1,324 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ManifestRecord is an image manifest held by the local registry
+type ManifestRecord struct {
+	Digest       string
+	MediaType    string
+	Size         int64
+	OS           string
+	Architecture string
+	Variant      string
+}
+
+// Platform formats the manifest's platform as os/arch[/variant], or "" if unknown
+func (m ManifestRecord) Platform() string {
+	if m.OS == "" {
+		return ""
+	}
+	p := m.OS + "/" + m.Architecture
+	if m.Variant != "" {
+		p += "/" + m.Variant
+	}
+	return p
+}
+
+// BlobRef is a blob (config or layer) a manifest references
+type BlobRef struct {
+	Digest    string
+	Size      int64
+	MediaType string
+}
+
+// TagRecord is a tag in the local registry and the manifest it points at
+type TagRecord struct {
+	Repository string
+	Tag        string
+	Manifest   ManifestRecord
+	Updated    time.Time
+}
+
+// Ref returns repository:tag
+func (t TagRecord) Ref() string {
+	return t.Repository + ":" + t.Tag
+}
+
+// tagRef is the SQL for a tag's repository:tag, with tags aliased t and repositories r
+const tagRef = `r.name || ':' || t.name`
+
+// RecordImage stores a tag, the manifest it points at and the blobs that
+// manifest references, replacing whatever the tag pointed at before
+func (db *sqlDB) RecordImage(repository, tag string, manifest ManifestRecord, blobs []BlobRef) error {
+	tx, err := db.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`INSERT INTO repositories (name) VALUES (?) ON CONFLICT (name) DO NOTHING`, repository); err != nil {
+		return err
+	}
+	var repoID int64
+	if err := tx.QueryRow(`SELECT id FROM repositories WHERE name = ?`, repository).Scan(&repoID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO manifests (digest, media_type, size, os, architecture, variant, created)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(digest) DO UPDATE SET
+			media_type = excluded.media_type, size = excluded.size,
+			os = excluded.os, architecture = excluded.architecture, variant = excluded.variant`,
+		manifest.Digest, manifest.MediaType, manifest.Size,
+		manifest.OS, manifest.Architecture, manifest.Variant, time.Now()); err != nil {
+		return err
+	}
+
+	for _, b := range blobs {
+		if _, err := tx.Exec(`
+			INSERT INTO blobs (digest, size, media_type) VALUES (?, ?, ?)
+			ON CONFLICT (digest) DO UPDATE SET size = excluded.size, media_type = excluded.media_type`, b.Digest, b.Size, b.MediaType); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO manifest_blobs (manifest_digest, blob_digest) VALUES (?, ?) ON CONFLICT DO NOTHING`, manifest.Digest, b.Digest); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO tags (repository_id, name, manifest_digest, updated) VALUES (?, ?, ?, ?)
+		ON CONFLICT (repository_id, name) DO UPDATE SET manifest_digest = excluded.manifest_digest, updated = excluded.updated`,
+		repoID, tag, manifest.Digest, time.Now()); err != nil {
+		return err
+	}
+
+	if err := deleteUnreferenced(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveImage drops a tag. Manifests and blobs no other tag references are
+// forgotten as well.
+func (db *sqlDB) RemoveImage(repository, tag string) error {
+	tx, err := db.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		DELETE FROM tags
+		WHERE name = ? AND repository_id = (SELECT id FROM repositories WHERE name = ?)`, tag, repository); err != nil {
+		return err
+	}
+	if err := deleteUnreferenced(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteUnreferenced removes manifests no tag points at, and blobs no
+// remaining manifest references
+func deleteUnreferenced(tx *txn) error {
+	_, err := tx.Exec(`
+		DELETE FROM manifest_blobs WHERE manifest_digest NOT IN (SELECT manifest_digest FROM tags);
+		DELETE FROM manifests WHERE digest NOT IN (SELECT manifest_digest FROM tags);
+		DELETE FROM blobs WHERE digest NOT IN (SELECT blob_digest FROM manifest_blobs);
+		DELETE FROM repositories WHERE id NOT IN (SELECT repository_id FROM tags);`)
+	return err
+}
+
+// GetTags returns every tag in the local registry, or only those of one
+// repository when repository isn't empty
+func (db *sqlDB) GetTags(repository string) ([]TagRecord, error) {
+	query := `
+		SELECT r.name, t.name, t.updated, m.digest, COALESCE(m.media_type, ''), m.size,
+			COALESCE(m.os, ''), COALESCE(m.architecture, ''), COALESCE(m.variant, '')
+		FROM tags t
+		JOIN repositories r ON r.id = t.repository_id
+		JOIN manifests m ON m.digest = t.manifest_digest
+		WHERE ? = '' OR r.name = ?
+		ORDER BY r.name, t.name`
+	rows, err := db.query(query, repository, repository)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []TagRecord
+	for rows.Next() {
+		var t TagRecord
+		m := &t.Manifest
+		if err := rows.Scan(&t.Repository, &t.Tag, &t.Updated, &m.Digest, &m.MediaType, &m.Size, &m.OS, &m.Architecture, &m.Variant); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+// GetTag returns a single tag, or nil if the local registry doesn't hold it
+func (db *sqlDB) GetTag(repository, tag string) (*TagRecord, error) {
+	tags, err := db.GetTags(repository)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tags {
+		if t.Tag == tag {
+			return &t, nil
+		}
+	}
+	return nil, nil
+}
+
+// GetManifestBlobs returns the blobs a manifest references, largest first
+func (db *sqlDB) GetManifestBlobs(digest string) ([]BlobRef, error) {
+	rows, err := db.query(`
+		SELECT b.digest, b.size, COALESCE(b.media_type, '')
+		FROM manifest_blobs mb
+		JOIN blobs b ON b.digest = mb.blob_digest
+		WHERE mb.manifest_digest = ?
+		ORDER BY b.size DESC`, digest)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blobs []BlobRef
+	for rows.Next() {
+		var b BlobRef
+		if err := rows.Scan(&b.Digest, &b.Size, &b.MediaType); err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, b)
+	}
+	return blobs, rows.Err()
+}
+
+// GetCacheUsage returns how many distinct blobs the local registry holds and
+// their total size, counting shared layers once
+func (db *sqlDB) GetCacheUsage() (int, int64, error) {
+	row := db.queryRow(`SELECT COUNT(*), COALESCE(SUM(size), 0) FROM blobs`)
+	var count int
+	var bytes int64
+	if err := row.Scan(&count, &bytes); err != nil {
+		return 0, 0, err
+	}
+	return count, bytes, nil
+}
+
+// GetImageSizes returns the total size of the blobs behind every tag, keyed
+// by repository:tag
+func (db *sqlDB) GetImageSizes() (map[string]int64, error) {
+	rows, err := db.query(`
+		SELECT ` + tagRef + `, COALESCE(SUM(b.size), 0)
+		FROM tags t
+		JOIN repositories r ON r.id = t.repository_id
+		JOIN manifest_blobs mb ON mb.manifest_digest = t.manifest_digest
+		JOIN blobs b ON b.digest = mb.blob_digest
+		GROUP BY r.name, t.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sizes := make(map[string]int64)
+	for rows.Next() {
+		var ref string
+		var size int64
+		if err := rows.Scan(&ref, &size); err != nil {
+			return nil, err
+		}
+		sizes[ref] = size
+	}
+	return sizes, rows.Err()
+}
+
+// GetTagBlobs returns the blobs behind every tag, keyed by repository:tag
+func (db *sqlDB) GetTagBlobs() (map[string][]BlobRef, error) {
+	rows, err := db.query(`
+		SELECT ` + tagRef + `, b.digest, b.size, COALESCE(b.media_type, '')
+		FROM tags t
+		JOIN repositories r ON r.id = t.repository_id
+		JOIN manifest_blobs mb ON mb.manifest_digest = t.manifest_digest
+		JOIN blobs b ON b.digest = mb.blob_digest`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blobs := make(map[string][]BlobRef)
+	for rows.Next() {
+		var ref string
+		var b BlobRef
+		if err := rows.Scan(&ref, &b.Digest, &b.Size, &b.MediaType); err != nil {
+			return nil, err
+		}
+		blobs[ref] = append(blobs[ref], b)
+	}
+	return blobs, rows.Err()
+}
+
+// GetMissingBytes returns the total size of the given blobs the local
+// registry doesn't hold yet
+func (db *sqlDB) GetMissingBytes(blobs []BlobRef) (int64, error) {
+	var missing int64
+	for _, b := range blobs {
+		var n int
+		if err := db.queryRow(`SELECT COUNT(*) FROM blobs WHERE digest = ?`, b.Digest).Scan(&n); err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			missing += b.Size
+		}
+	}
+	return missing, nil
+}
+
+// GetBlobRefCount returns how many tags reference a blob
+func (db *sqlDB) GetBlobRefCount(digest string) (int, error) {
+	var count int
+	err := db.queryRow(`
+		SELECT COUNT(*) FROM tags t
+		JOIN manifest_blobs mb ON mb.manifest_digest = t.manifest_digest
+		WHERE mb.blob_digest = ?`, digest).Scan(&count)
+	return count, err
+}
+
+// GetFreedBytes returns how many bytes removing all the given tags
+// (repository:tag) frees: the size of the blobs that only these tags reference
+func (db *sqlDB) GetFreedBytes(refs []string) (int64, error) {
+	if len(refs) == 0 {
+		return 0, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(refs)), ",")
+	query := fmt.Sprintf(`
+		WITH tag_blobs AS (
+			SELECT `+tagRef+` AS ref, mb.blob_digest AS digest
+			FROM tags t
+			JOIN repositories r ON r.id = t.repository_id
+			JOIN manifest_blobs mb ON mb.manifest_digest = t.manifest_digest
+		)
+		SELECT COALESCE(SUM(b.size), 0)
+		FROM blobs b
+		WHERE b.digest IN (SELECT digest FROM tag_blobs WHERE ref IN (%[1]s))
+		AND b.digest NOT IN (SELECT digest FROM tag_blobs WHERE ref NOT IN (%[1]s))`, placeholders)
+
+	args := make([]interface{}, 0, 2*len(refs))
+	for i := 0; i < 2; i++ {
+		for _, ref := range refs {
+			args = append(args, ref)
+		}
+	}
+
+	var freed int64
+	err := db.queryRow(query, args...).Scan(&freed)
+	return freed, err
+}
@@ -0,0 +1,62 @@
+package storage
+
+import "testing"
+
+func TestRecordImageTracksSharedBlobs(t *testing.T) {
+	db, err := Open("memory://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	base := BlobRef{Digest: "sha256:base", Size: 100}
+	amd64 := ManifestRecord{Digest: "sha256:m1", MediaType: "application/vnd.docker.distribution.manifest.v2+json", OS: "linux", Architecture: "amd64"}
+	if err := db.RecordImage("nginx", "1.25", amd64, []BlobRef{base, {Digest: "sha256:a", Size: 10}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RecordImage("nginx", "latest", amd64, []BlobRef{base, {Digest: "sha256:a", Size: 10}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RecordImage("redis", "7", ManifestRecord{Digest: "sha256:m2"}, []BlobRef{base, {Digest: "sha256:b", Size: 20}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tags, err := db.GetTags("nginx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 2 || tags[0].Ref() != "nginx:1.25" || tags[0].Manifest.Platform() != "linux/amd64" {
+		t.Errorf("Unexpected nginx tags: %+v", tags)
+	}
+
+	if _, used, _ := db.GetCacheUsage(); used != 130 {
+		t.Errorf("Expected 130 bytes in use, got %d", used)
+	}
+	if n, _ := db.GetBlobRefCount("sha256:base"); n != 3 {
+		t.Errorf("Expected base layer referenced by 3 tags, got %d", n)
+	}
+
+	// nginx:1.25 shares its manifest with nginx:latest, so removing it frees nothing
+	if freed, _ := db.GetFreedBytes([]string{"nginx:1.25"}); freed != 0 {
+		t.Errorf("Expected nothing freed, got %d", freed)
+	}
+	if freed, _ := db.GetFreedBytes([]string{"nginx:1.25", "nginx:latest"}); freed != 10 {
+		t.Errorf("Expected 10 bytes freed, got %d", freed)
+	}
+	if blobs, _ := db.GetTagBlobs(); len(blobs) != 3 || len(blobs["redis:7"]) != 2 {
+		t.Errorf("Expected the blobs of 3 tags, got %v", blobs)
+	}
+
+	db.RemoveImage("nginx", "1.25")
+	db.RemoveImage("nginx", "latest")
+
+	if _, used, _ := db.GetCacheUsage(); used != 120 {
+		t.Errorf("Expected 120 bytes in use after removing nginx, got %d", used)
+	}
+	if tag, _ := db.GetTag("nginx", "latest"); tag != nil {
+		t.Errorf("Expected nginx:latest to be gone, got %+v", tag)
+	}
+	if blobs, _ := db.GetManifestBlobs("sha256:m1"); len(blobs) != 0 {
+		t.Errorf("Expected unreferenced manifest to be dropped, got %v", blobs)
+	}
+}
@@ -0,0 +1,298 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/saurabh12nxf/registry-mirror/internal/filelock"
+)
+
+// Migration is one numbered, forward-only schema change. Migrations are
+// applied in order and never edited once released: add a new one instead.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// migrations up to 4 use IF NOT EXISTS so databases created before
+// versioning was introduced are adopted as they are. {{id}} and {{time}} stand
+// for the dialect's auto-incrementing key and timestamp column types, and
+// {{utc column}} for the column's time converted to UTC.
+var migrations = []Migration{
+	{1, "sync history", `
+	CREATE TABLE IF NOT EXISTS syncs (
+		id {{id}},
+		image TEXT NOT NULL,
+		status TEXT NOT NULL,
+		bytes BIGINT DEFAULT 0,
+		duration REAL DEFAULT 0,
+		timestamp {{time}} DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_image ON syncs(image);`},
+
+	{2, "pull history", `
+	CREATE TABLE IF NOT EXISTS pulls (
+		id {{id}},
+		image TEXT NOT NULL,
+		tag TEXT NOT NULL,
+		digest TEXT,
+		client_ip TEXT,
+		cache_hit BOOLEAN DEFAULT FALSE,
+		timestamp {{time}} DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_pulls_image ON pulls(image, tag);`},
+
+	{3, "registry inventory", `
+	DROP TABLE IF EXISTS image_blobs;
+	DROP TABLE IF EXISTS cached_blobs;
+	DROP TABLE IF EXISTS cached_images;
+
+	CREATE TABLE IF NOT EXISTS repositories (
+		id {{id}},
+		name TEXT NOT NULL UNIQUE
+	);
+
+	CREATE TABLE IF NOT EXISTS manifests (
+		digest TEXT PRIMARY KEY,
+		media_type TEXT,
+		size BIGINT DEFAULT 0,
+		os TEXT,
+		architecture TEXT,
+		variant TEXT,
+		created {{time}} DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS tags (
+		repository_id BIGINT NOT NULL REFERENCES repositories(id),
+		name TEXT NOT NULL,
+		manifest_digest TEXT NOT NULL REFERENCES manifests(digest),
+		updated {{time}} DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (repository_id, name)
+	);
+	CREATE INDEX IF NOT EXISTS idx_tags_manifest ON tags(manifest_digest);
+
+	CREATE TABLE IF NOT EXISTS blobs (
+		digest TEXT PRIMARY KEY,
+		size BIGINT NOT NULL DEFAULT 0,
+		media_type TEXT
+	);
+
+	CREATE TABLE IF NOT EXISTS manifest_blobs (
+		manifest_digest TEXT NOT NULL REFERENCES manifests(digest),
+		blob_digest TEXT NOT NULL REFERENCES blobs(digest),
+		PRIMARY KEY (manifest_digest, blob_digest)
+	);
+	CREATE INDEX IF NOT EXISTS idx_manifest_blobs_blob ON manifest_blobs(blob_digest);`},
+
+	{4, "pinned images", `
+	CREATE TABLE IF NOT EXISTS pins (
+		image TEXT PRIMARY KEY,
+		timestamp {{time}} DEFAULT CURRENT_TIMESTAMP
+	);`},
+
+	{5, "structured sync records", `
+	ALTER TABLE syncs ADD COLUMN bytes_skipped BIGINT DEFAULT 0;
+	ALTER TABLE syncs ADD COLUMN layers INTEGER DEFAULT 0;
+	ALTER TABLE syncs ADD COLUMN layers_skipped INTEGER DEFAULT 0;
+	ALTER TABLE syncs ADD COLUMN upstream_digest TEXT;
+	ALTER TABLE syncs ADD COLUMN local_digest TEXT;
+	ALTER TABLE syncs ADD COLUMN error_category TEXT;
+	ALTER TABLE syncs ADD COLUMN error_message TEXT;
+
+	UPDATE syncs SET status = 'failed', error_message = SUBSTR(status, 9)
+	WHERE status LIKE 'failed: %';
+	CREATE INDEX IF NOT EXISTS idx_syncs_status ON syncs(status, error_category);`},
+
+	{6, "in-progress syncs", `
+	ALTER TABLE syncs ADD COLUMN heartbeat {{time}};
+
+	CREATE TABLE IF NOT EXISTS sync_layers (
+		sync_id BIGINT NOT NULL REFERENCES syncs(id) ON DELETE CASCADE,
+		digest TEXT NOT NULL,
+		size BIGINT DEFAULT 0,
+		status TEXT NOT NULL,
+		updated {{time}} DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (sync_id, digest)
+	);`},
+
+	// SQLite compares times as text: times written with the local offset
+	// don't sort against UTC ones
+	{7, "UTC timestamps", `
+	UPDATE syncs SET timestamp = {{utc timestamp}}, heartbeat = {{utc heartbeat}};
+	UPDATE pulls SET timestamp = {{utc timestamp}};
+	UPDATE pins SET timestamp = {{utc timestamp}};
+	UPDATE manifests SET created = {{utc created}};
+	UPDATE tags SET updated = {{utc updated}};
+	UPDATE sync_layers SET updated = {{utc updated}};
+	UPDATE schema_version SET applied = {{utc applied}};`},
+
+	// Two processes could both start syncing an image; keep the newest
+	{8, "one running sync per image", `
+	UPDATE syncs SET status = 'interrupted'
+	WHERE status = 'running' AND id NOT IN (SELECT MAX(id) FROM syncs WHERE status = 'running' GROUP BY image);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_syncs_running ON syncs(image) WHERE status = 'running';`},
+}
+
+// LatestVersion is the schema version this build migrates to
+func LatestVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// MigrationStatus is a migration and when it was applied (zero if pending)
+type MigrationStatus struct {
+	Migration
+	Applied time.Time
+}
+
+func (db *sqlDB) ensureVersionTable() error {
+	_, err := db.exec(db.dialect.schema(`
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied {{time}} DEFAULT CURRENT_TIMESTAMP
+	)`))
+	return err
+}
+
+// SchemaVersion returns the version of the last applied migration, 0 for a
+// database that was never migrated
+func (db *sqlDB) SchemaVersion() (int, error) {
+	if err := db.ensureVersionTable(); err != nil {
+		return 0, err
+	}
+	var version int
+	err := db.queryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	return version, err
+}
+
+// MigrationStatus lists every known migration with when it was applied
+func (db *sqlDB) MigrationStatus() ([]MigrationStatus, error) {
+	if err := db.ensureVersionTable(); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]time.Time)
+	rows, err := db.query(`SELECT version, applied FROM schema_version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status = append(status, MigrationStatus{Migration: m, Applied: applied[m.Version]})
+	}
+	return status, nil
+}
+
+// Migrate applies every pending migration, each in its own transaction. When
+// an existing database is about to change, a copy is saved next to it first
+// and its path returned.
+//
+// Processes opening the same SQLite file at once take turns: the first one
+// backs up and migrates, the others then find nothing left to do.
+func (db *sqlDB) Migrate() (applied []Migration, backup string, err error) {
+	current, err := db.SchemaVersion()
+	if err != nil {
+		return nil, "", err
+	}
+	if current >= LatestVersion() {
+		return nil, "", nil
+	}
+
+	if db.path != "" {
+		unlock, err := filelock.Lock(db.path + ".lock")
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to lock database for migrating: %w", err)
+		}
+		defer unlock()
+
+		// Another process may have migrated while we waited
+		if current, err = db.SchemaVersion(); err != nil {
+			return nil, "", err
+		}
+		if current >= LatestVersion() {
+			return nil, "", nil
+		}
+
+		if db.hasData() {
+			backup = fmt.Sprintf("%s.v%d-%s.bak", db.path, current, time.Now().Format("20060102-150405"))
+			if _, err := db.exec(`VACUUM INTO ?`, backup); err != nil {
+				return nil, "", fmt.Errorf("failed to back up database before migrating: %w", err)
+			}
+		}
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		ok, err := db.apply(m)
+		if err != nil {
+			return applied, backup, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		if ok {
+			applied = append(applied, m)
+		}
+	}
+	return applied, backup, nil
+}
+
+// migrationLock is the PostgreSQL advisory lock migrating processes take
+const migrationLock = 0x6d6972726f72 // "mirror"
+
+// apply runs a migration in a transaction holding the write lock, unless the
+// migration turns out to be recorded already by another process
+func (db *sqlDB) apply(m Migration) (bool, error) {
+	tx, err := db.begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// SQLite transactions are immediate and so hold the write lock already
+	if db.dialect.name == "postgres" {
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(?)`, migrationLock); err != nil {
+			return false, err
+		}
+	}
+
+	var current int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&current); err != nil {
+		return false, err
+	}
+	if current >= m.Version {
+		return false, nil
+	}
+
+	if _, err := tx.Exec(db.dialect.schema(m.SQL)); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_version (version, name, applied) VALUES (?, ?, ?)`, m.Version, m.Name, time.Now()); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// hasData reports whether the SQLite file holds any table besides schema_version
+func (db *sqlDB) hasData() bool {
+	if info, err := os.Stat(db.path); err != nil || info.Size() == 0 {
+		return false
+	}
+	var n int
+	db.queryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name NOT IN ('schema_version', 'sqlite_sequence')`).Scan(&n)
+	return n > 0
+}
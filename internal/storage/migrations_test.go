@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestOpenMigratesLegacyDatabaseWithBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+
+	// A database created before migrations existed
+	legacy, err := openSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := legacy.conn.Exec(sqliteDialect.schema(migrations[0].SQL)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := legacy.conn.Exec(`INSERT INTO syncs (image, status, bytes, duration) VALUES
+		('nginx:latest', 'completed', 10, 1),
+		('redis:latest', 'failed: failed to get manifest: status 404', 0, 0)`); err != nil {
+		t.Fatal(err)
+	}
+	legacy.Close()
+
+	db, err := OpenUnmigrated(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	applied, backup, err := db.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) {
+		t.Errorf("Expected %d migrations applied, got %d", len(migrations), len(applied))
+	}
+	if _, err := os.Stat(backup); err != nil {
+		t.Errorf("Expected a backup at %q: %v", backup, err)
+	}
+
+	if v, _ := db.SchemaVersion(); v != LatestVersion() {
+		t.Errorf("Expected schema version %d, got %d", LatestVersion(), v)
+	}
+	if rec, _ := db.GetLatestSync("nginx:latest"); rec == nil {
+		t.Error("Expected existing sync history to survive the migration")
+	}
+	if rec, _ := db.GetLatestSync("redis:latest"); rec == nil || rec.Status != "failed" || rec.Error != "failed to get manifest: status 404" {
+		t.Errorf("Expected legacy failure to be split into status and message, got %+v", rec)
+	}
+
+	// Nothing left to do, so no second backup
+	if applied, backup, _ := db.Migrate(); len(applied) != 0 || backup != "" {
+		t.Errorf("Expected no-op migration, got %v %q", applied, backup)
+	}
+}
+
+func TestConcurrentMigrateRunsOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shared.db")
+
+	// A database at version 1 with data, so migrating takes a backup
+	old, err := openSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := old.ensureVersionTable(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.apply(migrations[0]); err != nil {
+		t.Fatal(err)
+	}
+	old.exec(`INSERT INTO syncs (image, status, bytes, duration) VALUES ('nginx:latest', 'completed', 10, 1)`)
+	old.Close()
+
+	// Like the daemon and 'status' starting at the same time
+	var wg sync.WaitGroup
+	applied := make([]int, 2)
+	backups := make([]string, 2)
+	errs := make([]error, 2)
+	for i := range applied {
+		db, err := OpenUnmigrated(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var done []Migration
+			done, backups[i], errs[i] = db.Migrate()
+			applied[i] = len(done)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if applied[0]+applied[1] != len(migrations)-1 {
+		t.Errorf("Expected every migration applied once, got %v", applied)
+	}
+	if (backups[0] == "") == (backups[1] == "") {
+		t.Errorf("Expected exactly one backup, got %q", backups)
+	}
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Sync statuses besides completed, failed and evicted
+const (
+	StatusRunning     = "running"
+	StatusInterrupted = "interrupted"
+)
+
+// LayerProgress is the state of one layer within a running sync: "done",
+// "skipped" (already in the local registry) or "failed"
+type LayerProgress struct {
+	Digest  string
+	Size    int64
+	Status  string
+	Updated time.Time
+}
+
+// ErrSyncRunning is returned by StartSync when the image has a running sync already
+var ErrSyncRunning = errors.New("a sync of the image is already running")
+
+// StartSync records a sync as running and returns its id, which the outcome
+// is later recorded under with FinishSync. An image has one running sync at
+// most, however many processes try to start one.
+func (db *sqlDB) StartSync(image string) (int, error) {
+	now := time.Now()
+	var id int
+	// idx_syncs_running makes the check and the insert one step
+	err := db.queryRow(`
+		INSERT INTO syncs (image, status, timestamp, heartbeat) VALUES (?, ?, ?, ?)
+		ON CONFLICT (image) WHERE status = 'running' DO NOTHING
+		RETURNING id`,
+		image, StatusRunning, now, now).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrSyncRunning
+	}
+	return id, err
+}
+
+// Heartbeat tells other processes a running sync is still alive
+func (db *sqlDB) Heartbeat(id int) error {
+	_, err := db.exec(`UPDATE syncs SET heartbeat = ? WHERE id = ?`, time.Now(), id)
+	return err
+}
+
+// RecordLayer stores the progress of one layer of a running sync
+func (db *sqlDB) RecordLayer(id int, layer LayerProgress) error {
+	tx, err := db.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if _, err := tx.Exec(`
+		INSERT INTO sync_layers (sync_id, digest, size, status, updated) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (sync_id, digest) DO UPDATE SET status = excluded.status, updated = excluded.updated`,
+		id, layer.Digest, layer.Size, layer.Status, now); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE syncs SET heartbeat = ? WHERE id = ?`, now, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// FinishSync replaces a running sync with its outcome
+func (db *sqlDB) FinishSync(id int, rec SyncRecord) error {
+	if rec.Timestamp.IsZero() {
+		rec.Timestamp = time.Now()
+	}
+	_, err := db.exec(`
+		UPDATE syncs SET status = ?, bytes = ?, duration = ?, bytes_skipped = ?, layers = ?, layers_skipped = ?,
+			upstream_digest = ?, local_digest = ?, error_category = ?, error_message = ?, timestamp = ?, heartbeat = ?
+		WHERE id = ?`,
+		rec.Status, rec.Bytes, rec.Duration, rec.BytesSkipped, rec.Layers, rec.LayersSkipped,
+		rec.UpstreamDigest, rec.LocalDigest, rec.ErrorCategory, rec.Error, rec.Timestamp, rec.Timestamp, id)
+	return err
+}
+
+// DeleteSync forgets a sync that never got going, along with its layers
+func (db *sqlDB) DeleteSync(id int) error {
+	tx, err := db.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM sync_layers WHERE sync_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM syncs WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MarkInterrupted marks running syncs whose last heartbeat is older than
+// staleBefore as interrupted, returning how many there were
+func (db *sqlDB) MarkInterrupted(staleBefore time.Time) (int, error) {
+	res, err := db.exec(`UPDATE syncs SET status = ? WHERE status = ? AND heartbeat < ?`,
+		StatusInterrupted, StatusRunning, staleBefore)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// GetInterruptedSyncs returns the images whose latest sync was interrupted,
+// oldest first
+func (db *sqlDB) GetInterruptedSyncs() ([]SyncRecord, error) {
+	rows, err := db.query(`
+		SELECT `+syncColumns+` FROM syncs
+		WHERE id IN (SELECT MAX(id) FROM syncs GROUP BY image)
+		AND status = ?
+		ORDER BY timestamp ASC`, StatusInterrupted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []SyncRecord
+	for rows.Next() {
+		rec, err := scanSync(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// GetSyncLayers returns the layer progress of a sync, in the order it was made
+func (db *sqlDB) GetSyncLayers(id int) ([]LayerProgress, error) {
+	rows, err := db.query(`SELECT digest, size, status, updated FROM sync_layers WHERE sync_id = ? ORDER BY updated`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var layers []LayerProgress
+	for rows.Next() {
+		var l LayerProgress
+		if err := rows.Scan(&l.Digest, &l.Size, &l.Status, &l.Updated); err != nil {
+			return nil, err
+		}
+		layers = append(layers, l)
+	}
+	return layers, rows.Err()
+}
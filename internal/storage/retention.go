@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy decides which sync records are old enough to delete. Zero
+// fields don't limit anything.
+type RetentionPolicy struct {
+	// Before drops records older than this
+	Before time.Time
+	// KeepPerImage drops all but the newest KeepPerImage records of every image
+	KeepPerImage int
+}
+
+// IsZero reports whether the policy keeps everything
+func (p RetentionPolicy) IsZero() bool {
+	return p.Before.IsZero() && p.KeepPerImage <= 0
+}
+
+// PruneSyncs deletes the sync records the policy doesn't keep and returns how
+// many there were, or only counts them when dryRun is set. Running syncs, the
+// latest record of every image and the latest completed or evicted one (which
+// cache accounting relies on) are always kept.
+func (db *sqlDB) PruneSyncs(policy RetentionPolicy, dryRun bool) (int, error) {
+	if policy.IsZero() {
+		return 0, nil
+	}
+
+	var limits []string
+	var args []interface{}
+	if !policy.Before.IsZero() {
+		limits = append(limits, "timestamp < ?")
+		args = append(args, policy.Before)
+	}
+	if policy.KeepPerImage > 0 {
+		limits = append(limits, `id IN (
+			SELECT id FROM (
+				SELECT id, ROW_NUMBER() OVER (PARTITION BY image ORDER BY timestamp DESC, id DESC) AS n FROM syncs
+			) ranked WHERE n > ?
+		)`)
+		args = append(args, policy.KeepPerImage)
+	}
+
+	where := fmt.Sprintf(`
+		status <> '%s'
+		AND id NOT IN (SELECT MAX(id) FROM syncs GROUP BY image)
+		AND id NOT IN (SELECT MAX(id) FROM syncs WHERE status IN ('completed', 'evicted') GROUP BY image)
+		AND (%s)`, StatusRunning, strings.Join(limits, " OR "))
+
+	if dryRun {
+		var n int
+		err := db.queryRow(`SELECT COUNT(*) FROM syncs WHERE `+where, args...).Scan(&n)
+		return n, err
+	}
+
+	tx, err := db.begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM syncs WHERE `+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`DELETE FROM sync_layers WHERE sync_id NOT IN (SELECT id FROM syncs)`); err != nil {
+		return 0, err
+	}
+	return int(n), tx.Commit()
+}
+
+// Vacuum gives the space of deleted rows back to the file system (SQLite) or
+// marks it reusable and refreshes planner statistics (PostgreSQL)
+func (db *sqlDB) Vacuum() error {
+	if db.dialect.name == postgresDialect.name {
+		_, err := db.exec(`VACUUM ANALYZE`)
+		return err
+	}
+	_, err := db.exec(`VACUUM`)
+	return err
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPruneSyncsKeepsWhatTheCacheNeeds(t *testing.T) {
+	db, err := Open("memory://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	day := 24 * time.Hour
+	records := []SyncRecord{
+		{Image: "nginx:latest", Status: "completed", Timestamp: now.Add(-90 * day)}, // still cached: kept
+		{Image: "nginx:latest", Status: "failed", Timestamp: now.Add(-80 * day)},
+		{Image: "nginx:latest", Status: "failed", Timestamp: now.Add(-70 * day)}, // latest: kept
+		{Image: "redis:7", Status: "completed", Timestamp: now.Add(-60 * day)},
+		{Image: "redis:7", Status: "completed", Timestamp: now.Add(-2 * day)},
+		{Image: "redis:7", Status: "failed", Timestamp: now.Add(-1 * day)},
+	}
+	for _, rec := range records {
+		if err := db.RecordSync(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	policy := RetentionPolicy{Before: now.Add(-30 * day)}
+	if n, _ := db.PruneSyncs(policy, true); n != 2 {
+		t.Errorf("Expected a dry run to count 2 records, got %d", n)
+	}
+	if n, err := db.PruneSyncs(policy, false); err != nil || n != 2 {
+		t.Fatalf("Expected 2 records pruned, got %d (%v)", n, err)
+	}
+
+	cached, _ := db.GetCachedImages()
+	if len(cached) != 2 {
+		t.Errorf("Expected both images to stay cached, got %+v", cached)
+	}
+
+	// Keeping one record per image leaves nginx's latest and its completed sync
+	if n, _ := db.PruneSyncs(RetentionPolicy{KeepPerImage: 1}, false); n != 0 {
+		t.Errorf("Expected nothing else prunable, got %d", n)
+	}
+	if left, _ := db.GetSyncs(SyncFilter{}); len(left) != 4 {
+		t.Errorf("Expected 4 records left, got %d", len(left))
+	}
+}